@@ -0,0 +1,166 @@
+package atomicfile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// TempDirName is the directory of a repository where files are written before they are moved into place.
+	TempDirName = "tmp"
+	tempSuffix  = ".tmp"
+	// Temporary files modified more recently may belong to a concurrent write
+	sweepMinAge = time.Hour
+)
+
+// WriteFile writes data to a temporary file in the repository at root, syncs it and renames it to path.
+// An existing file at path is replaced. Readers see either the old or the complete new file, even after a crash.
+func WriteFile(root, path string, data []byte, perm os.FileMode) error {
+	return write(root, path, data, perm, true)
+}
+
+// WriteNewFile is like WriteFile, but fails if path exists.
+func WriteNewFile(root, path string, data []byte, perm os.FileMode) error {
+	return write(root, path, data, perm, false)
+}
+
+// Sweep removes temporary files which were left behind by interrupted writes.
+func Sweep(root string) error {
+	tempDir := filepath.Join(root, TempDirName)
+	entries, err := ioutil.ReadDir(tempDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to list temporary files: %s", err.Error())
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), tempSuffix) || time.Since(entry.ModTime()) < sweepMinAge {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(tempDir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove temporary file: %s", err.Error())
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		log.WithField("count", removed).Info("Removed leftover temporary files")
+	}
+	return nil
+}
+
+func write(root, path string, data []byte, perm os.FileMode, replace bool) error {
+	tempDir := filepath.Join(root, TempDirName)
+	if err := makeDirs(tempDir); err != nil {
+		return fmt.Errorf("failed to create temporary directory: %s", err.Error())
+	}
+
+	tempFile, err := ioutil.TempFile(tempDir, filepath.Base(path)+".*"+tempSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %s", err.Error())
+	}
+	tempPath := tempFile.Name()
+	// Fails harmlessly once the file has been renamed
+	defer os.Remove(tempPath)
+
+	if err := writeAndSync(tempFile, data, perm); err != nil {
+		return err
+	}
+
+	if err := makeDirs(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	if replace {
+		err = os.Rename(tempPath, path)
+	} else {
+		err = renameNew(tempPath, path)
+	}
+	if err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// renameNew renames oldPath to newPath unless newPath exists. Unlike rename, linking fails if the
+// destination exists. File systems without hard links fall back to checking for the destination before
+// renaming, which is not atomic.
+func renameNew(oldPath, newPath string) error {
+	err := os.Link(oldPath, newPath)
+	if err == nil || os.IsExist(err) {
+		return err
+	}
+
+	log.WithError(err).Debug("Failed to link file, falling back to rename")
+	if _, err := os.Lstat(newPath); err == nil {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: os.ErrExist}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+// makeDirs creates dir and its missing parents. The parent of each created directory is synced, so new
+// directories survive a crash like the files in them.
+func makeDirs(dir string) error {
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return nil
+	}
+
+	parent := filepath.Dir(dir)
+	if parent != dir {
+		if err := makeDirs(parent); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Mkdir(dir, 0755); err != nil {
+		// A concurrent write may have created it
+		if os.IsExist(err) {
+			return nil
+		}
+		return err
+	}
+	return syncDir(parent)
+}
+
+func writeAndSync(file *os.File, data []byte, perm os.FileMode) error {
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return fmt.Errorf("failed to write temporary file: %s", err.Error())
+	}
+
+	if err := file.Chmod(perm); err != nil {
+		return fmt.Errorf("failed to set file permissions: %s", err.Error())
+	}
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync temporary file: %s", err.Error())
+	}
+	return file.Close()
+}
+
+// syncDir persists the entries of a directory, e.g. a renamed file.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil && !os.IsPermission(err) {
+		return fmt.Errorf("failed to sync directory: %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/mboye/kopi/differ"
+	"github.com/mboye/kopi/index"
+	"github.com/mboye/kopi/manifest"
+	"github.com/mboye/kopi/model"
+	"github.com/mboye/kopi/outputhandler"
+	"github.com/mboye/kopi/repository"
+	"github.com/mboye/kopi/scanner"
+	"github.com/mboye/kopi/stage"
+	"github.com/mboye/kopi/storer"
+	log "github.com/sirupsen/logrus"
+)
+
+// Options configures the stages of a backup.
+type Options struct {
+	Scan             scanner.Options
+	Diff             differ.Options
+	MaxBlockSize     int64
+	Encrypt          bool
+	Description      string
+	ProgressInterval uint
+}
+
+type backup struct {
+	sourcePath string
+	backupDir  string
+	options    Options
+}
+
+var _ stage.Stage = (*backup)(nil)
+
+// New creates a stage which indexes sourcePath, diffs it against the latest manifest in backupDir,
+// stores modified files and writes a new manifest. A manifest is only written if all stages succeed.
+func New(sourcePath, backupDir string, options Options) (stage.Stage, error) {
+	if sourcePath == "" {
+		return nil, errors.New("cannot back up empty path")
+	}
+
+	if backupDir == "" {
+		return nil, errors.New("backup dir cannot be empty")
+	}
+
+	return &backup{sourcePath, backupDir, options}, nil
+}
+
+func (b *backup) Execute(ctx context.Context) error {
+	startTime := time.Now()
+
+	if _, err := repository.LoadConfig(b.backupDir); err != nil {
+		return err
+	}
+
+	previous, err := b.loadLatestManifest(ctx)
+	if err != nil {
+		return err
+	}
+
+	scan, err := scanner.New(b.sourcePath, b.options.Scan)
+	if err != nil {
+		return err
+	}
+
+	var fileCount, byteCount int64
+	diffSummary := differ.Summary{}
+	markedScan := stage.Observe(scan, func(file *model.File) {
+		differ.MarkChange(previous, file, b.options.Diff, &diffSummary)
+		fileCount++
+		if file.Modified {
+			byteCount += file.Size
+		}
+	})
+
+	// Backups cannot be resumed, so they keep no checkpoint and leave the one of an interrupted store alone
+	store, err := storer.New(b.backupDir, b.options.MaxBlockSize, b.options.Encrypt, false, false, b.options.ProgressInterval)
+	if err != nil {
+		return err
+	}
+
+	writer, err := manifest.NewWriter(b.backupDir, b.options.Encrypt, b.options.Description)
+	if err != nil {
+		return err
+	}
+
+	if err := stage.RunPipeline(ctx, markedScan, []stage.Filter{store}, writer); err != nil {
+		return fmt.Errorf("backup failed: %s", err.Error())
+	}
+
+	log.WithFields(log.Fields{
+		"manifest":                writer.ID(),
+		"files":                   fileCount,
+		"modified_files":          diffSummary.ModifiedFiles,
+		"content_modified_files":  diffSummary.ContentModifiedFiles,
+		"metadata_modified_files": diffSummary.MetadataModifiedFiles,
+		"modified_bytes":          humanize.Bytes(uint64(byteCount)),
+		"elapsed_time":            time.Since(startTime).Round(time.Second).String()}).Info("Backup completed")
+	return nil
+}
+
+func (b *backup) loadLatestManifest(ctx context.Context) (index.Index, error) {
+	previous := index.New()
+
+	latestID, err := manifest.Latest(b.backupDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if latestID == "" {
+		log.Info("No previous manifest found. All files will be stored.")
+		return previous, nil
+	}
+	log.WithField("id", latestID).Info("Diffing against latest manifest")
+
+	reader, err := manifest.NewReader(b.backupDir, b.options.Encrypt, latestID)
+	if err != nil {
+		return nil, err
+	}
+
+	reader.SetOutput(outputhandler.Func(func(obj interface{}) error {
+		return previous.Add(obj.(*model.File))
+	}))
+
+	if err := reader.Execute(ctx); err != nil {
+		return nil, fmt.Errorf("failed to load latest manifest: %s", err.Error())
+	}
+	log.WithField("size", previous.Size()).Info("Loaded latest manifest")
+
+	return previous, nil
+}
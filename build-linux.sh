@@ -12,3 +12,4 @@ build -o $output_dir/kopi-diff cmd/diff/diff.go
 build -o $output_dir/kopi-store cmd/store/store.go
 build -o $output_dir/kopi-restore cmd/restore/restore.go
 build -o $output_dir/kopi-manifest cmd/manifest/manifest.go
+build -o $output_dir/kopi cmd/kopi/kopi.go
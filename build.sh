@@ -6,3 +6,4 @@ go build -o bin/kopi-diff cmd/diff/diff.go
 go build -o bin/kopi-store cmd/store/store.go
 go build -o bin/kopi-restore cmd/restore/restore.go
 go build -o bin/kopi-manifest cmd/manifest/manifest.go
+go build -o bin/kopi cmd/kopi/kopi.go
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"context"
+	"flag"
+
+	"github.com/mboye/kopi/backup"
+	"github.com/mboye/kopi/differ"
+	"github.com/mboye/kopi/scanner"
+)
+
+var backupCommand = &command{
+	name:    "backup",
+	args:    "<source path> <backup dir>",
+	summary: "Index, diff against the latest manifest, store and write a new manifest in one go.",
+	setup: func(flags *flag.FlagSet) runFunc {
+		recursive := flags.Bool("recursive", true, "Back up path recursively")
+		oneFileSystem := flags.Bool("one-file-system", false, "Do not cross file system boundaries.")
+		workers := flags.Int("workers", 1, "Number of directories to read in parallel.")
+		contentHash := flags.Bool("content-hash", false, "Mark files with changed content hashes as modified, even if their metadata is unchanged.")
+		contentHashSample := flags.Int("content-hash-sample", 100, "Percentage of files to hash per run.")
+		compareInode := flags.Bool("inode", false, "Mark files with changed inode numbers as modified.")
+		compareChangeTime := flags.Bool("ctime", false, "Mark files with changed status change time as metadata modified.")
+		maxBlockSize := flags.Int64("maxBlockSize", 1024*1024*10, "Split files into blocks of this size")
+		encrypt := flags.Bool("encrypt", false, "Require an encrypted repository. Encryption is enabled by kopi init.")
+		description := flags.String("description", "", "Manifest description e.g. monthly backup 2019/1")
+		progressInterval := flags.Uint("progress", 10, "Progres printing interval in seconds.")
+
+		return func(ctx context.Context, g *globals, args []string) error {
+			backupDir, args, err := g.takeRepository(args, -1, 1)
+			if err != nil {
+				return err
+			}
+
+			if err := requireArgs(args, 1); err != nil {
+				return err
+			}
+
+			b, err := backup.New(args[0], backupDir, backup.Options{
+				Scan: scanner.Options{
+					Recursive:         *recursive,
+					WithProgress:      true,
+					OneFileSystem:     *oneFileSystem,
+					Workers:           *workers,
+					ContentHash:       *contentHash,
+					ContentHashSample: *contentHashSample},
+				Diff: differ.Options{
+					CompareContent:    *contentHash,
+					CompareInode:      *compareInode,
+					CompareChangeTime: *compareChangeTime},
+				MaxBlockSize:     *maxBlockSize,
+				Encrypt:          *encrypt,
+				Description:      *description,
+				ProgressInterval: *progressInterval})
+			if err != nil {
+				return err
+			}
+
+			return b.Execute(ctx)
+		}
+	},
+}
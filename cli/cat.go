@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"context"
+	"flag"
+
+	"github.com/mboye/kopi/restorer"
+)
+
+var catCommand = &command{
+	name:    "cat",
+	args:    "<backup dir> <manifest id> <path>",
+	summary: "Print the content of a file in a manifest on STDOUT.",
+	setup: func(flags *flag.FlagSet) runFunc {
+		decrypt := flags.Bool("decrypt", false, "Require an encrypted repository. Decryption is enabled by the repository config.")
+
+		return func(ctx context.Context, g *globals, args []string) error {
+			inputDir, args, err := g.takeRepository(args, 0, 2)
+			if err != nil {
+				return err
+			}
+
+			if err := requireArgs(args, 2); err != nil {
+				return err
+			}
+
+			c, err := restorer.NewCat(inputDir, args[0], args[1], *decrypt)
+			if err != nil {
+				return err
+			}
+
+			return c.Execute(ctx)
+		}
+	},
+}
@@ -0,0 +1,338 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	loglevel "github.com/mboye/kopi/loglevel"
+	"github.com/mboye/kopi/security"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	programName       = "kopi"
+	repositoryEnvVar  = "KOPI_REPOSITORY"
+	exitSuccess       = 0
+	exitFailure       = 1
+	exitInterrupted   = 130 // 128 + SIGINT, as reported by shells
+	argumentSeparator = "--"
+)
+
+// errUsage is returned by commands which were invoked with invalid arguments.
+var errUsage = errors.New("invalid arguments")
+
+// runFunc runs a command with its positional arguments. ctx is cancelled by SIGINT and SIGTERM.
+type runFunc func(ctx context.Context, g *globals, args []string) error
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	// setup defines the flags of the command and returns the function which runs it
+	setup       func(flags *flag.FlagSet) runFunc
+	subcommands []*command
+}
+
+// globals are the flags shared by all commands.
+type globals struct {
+	repository string
+	logLevel   string
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		initCommand,
+		indexCommand,
+		diffCommand,
+		storeCommand,
+		restoreCommand,
+		catCommand,
+		manifestCommand,
+		backupCommand,
+		importCommand,
+		keyCommand,
+		helpCommand,
+		completionCommand,
+	}
+}
+
+// Main runs the kopi command line and returns the exit code.
+func Main(args []string) int {
+	g := &globals{}
+	flags := flag.NewFlagSet(programName, flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	g.define(flags)
+
+	if err := flags.Parse(args); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n\n", err.Error())
+		printProgramUsage()
+		return exitFailure
+	}
+
+	if flags.NArg() == 0 {
+		printProgramUsage()
+		return exitFailure
+	}
+
+	cmd := findCommand(commands, flags.Arg(0))
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", flags.Arg(0))
+		printProgramUsage()
+		return exitFailure
+	}
+
+	return runCommand(g, []*command{cmd}, flags.Args()[1:])
+}
+
+// RunCommand runs a single command. It is used by the kopi-* binaries.
+func RunCommand(name string, args []string) int {
+	return Main(append([]string{name}, args...))
+}
+
+func runCommand(g *globals, path []*command, args []string) int {
+	cmd := path[len(path)-1]
+	if len(cmd.subcommands) > 0 {
+		if len(args) == 0 {
+			printCommandUsage(os.Stderr, path)
+			return exitFailure
+		}
+
+		subcommand := findCommand(cmd.subcommands, args[0])
+		if subcommand == nil {
+			fmt.Fprintf(os.Stderr, "Unknown command: %s %s\n\n", commandName(path), args[0])
+			printCommandUsage(os.Stderr, path)
+			return exitFailure
+		}
+		return runCommand(g, append(path, subcommand), args[1:])
+	}
+
+	flags := newFlagSet(path)
+	g.define(flags)
+	run := cmd.setup(flags)
+
+	positional, err := parseArgs(flags, args)
+	if err == flag.ErrHelp {
+		printCommandUsage(os.Stdout, path)
+		return exitSuccess
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n\n", err.Error())
+		printCommandUsage(os.Stderr, path)
+		return exitFailure
+	}
+
+	if err := g.apply(); err != nil {
+		log.Error(err)
+		return exitFailure
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	if err := run(ctx, g, positional); err == errUsage {
+		printCommandUsage(os.Stderr, path)
+		return exitFailure
+	} else if err != nil && ctx.Err() != nil {
+		log.WithError(err).Error("Interrupted")
+		return exitInterrupted
+	} else if err != nil {
+		log.Error(err)
+		return exitFailure
+	}
+	return exitSuccess
+}
+
+// interruptContext returns a context which is cancelled by the first SIGINT or SIGTERM. Later signals
+// are no longer caught, so a command which does not stop can still be killed by interrupting it again.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			log.WithField("signal", sig.String()).Warn("Received signal. Stopping.")
+			signal.Stop(signals)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+func (g *globals) define(flags *flag.FlagSet) {
+	// Defaults are taken from earlier definitions, so global flags may be given before or after the command
+	flags.StringVar(&g.repository, "repo", g.repository,
+		fmt.Sprintf("Backup repository directory. Overrides the repository argument, which defaults to $%s.", repositoryEnvVar))
+	flags.StringVar(&g.logLevel, "log-level", g.logLevel,
+		fmt.Sprintf("Log level: %s. Defaults to $%s.", strings.Join(loglevel.Levels, ", "), loglevel.EnvVar))
+	definePasswordFlags(flags, "password", security.Password)
+}
+
+func (g *globals) apply() error {
+	if g.logLevel != "" {
+		if err := loglevel.Set(g.logLevel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// definePasswordFlags defines the flags which select a password source. The source is updated directly,
+// so flags defined more than once keep the value given last.
+func definePasswordFlags(flags *flag.FlagSet, name string, source *security.PasswordSource) {
+	flags.IntVar(&source.FD, name+"-fd", source.FD,
+		fmt.Sprintf("Read the %s from this inherited file descriptor.", source.Name))
+	flags.StringVar(&source.File, name+"-file", source.File,
+		fmt.Sprintf("Read the %s from this file. A trailing line break is ignored.", source.Name))
+	flags.StringVar(&source.Command, name+"-command", source.Command,
+		fmt.Sprintf("Read the %s from the output of this shell command e.g. a secrets manager.", source.Name))
+	flags.StringVar(&source.EnvVar, name+"-env", source.EnvVar,
+		fmt.Sprintf("Environment variable holding the %s. Used if no descriptor, file or command is given, before prompting on the terminal.", source.Name))
+}
+
+// takeRepository returns the repository given by -repo, or takes it from the positional argument at index i.
+// count is the number of other positional arguments, so $KOPI_REPOSITORY is only used if the repository
+// argument is omitted.
+func (g *globals) takeRepository(args []string, i, count int) (string, []string, error) {
+	if g.repository != "" {
+		return g.repository, args, nil
+	}
+
+	if repository := os.Getenv(repositoryEnvVar); repository != "" && len(args) == count {
+		return repository, args, nil
+	}
+
+	if i < 0 {
+		i += len(args)
+	}
+
+	if i < 0 || i >= len(args) {
+		log.Error("Backup directory argument missing")
+		return "", nil, errUsage
+	}
+
+	remaining := append(append([]string{}, args[:i]...), args[i+1:]...)
+	return args[i], remaining, nil
+}
+
+// parseArgs parses flags, which may appear before, between and after positional arguments.
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var trailing []string
+	for i, arg := range args {
+		if arg == argumentSeparator {
+			args, trailing = args[:i], args[i+1:]
+			break
+		}
+	}
+
+	positional := []string{}
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+
+		args = flags.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	return append(positional, trailing...), nil
+}
+
+func requireArgs(args []string, count int) error {
+	if len(args) != count {
+		log.Error("Path argument missing")
+		return errUsage
+	}
+	return nil
+}
+
+func findCommand(candidates []*command, name string) *command {
+	for _, cmd := range candidates {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func commandName(path []*command) string {
+	names := []string{programName}
+	for _, cmd := range path {
+		names = append(names, cmd.name)
+	}
+	return strings.Join(names, " ")
+}
+
+func newFlagSet(path []*command) *flag.FlagSet {
+	flags := flag.NewFlagSet(commandName(path), flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	return flags
+}
+
+func printProgramUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [GLOBAL OPTIONS] <command> [OPTIONS] [ARGUMENTS]\n\n", programName)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	printCommandList(os.Stderr, commands)
+
+	fmt.Fprintln(os.Stderr, "\nGlobal options:")
+	flags := flag.NewFlagSet(programName, flag.ContinueOnError)
+	(&globals{}).define(flags)
+	flags.SetOutput(os.Stderr)
+	flags.PrintDefaults()
+
+	fmt.Fprintf(os.Stderr, "\nRun '%s help <command>' for more information on a command.\n", programName)
+}
+
+func printCommandList(w io.Writer, candidates []*command) {
+	sorted := append([]*command{}, candidates...)
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a].name < sorted[b].name })
+
+	for _, cmd := range sorted {
+		fmt.Fprintf(w, "  %-12s %s\n", cmd.name, cmd.summary)
+	}
+}
+
+func printCommandUsage(w io.Writer, path []*command) {
+	cmd := path[len(path)-1]
+	if len(cmd.subcommands) > 0 {
+		fmt.Fprintf(w, "Usage: %s <command> [OPTIONS] [ARGUMENTS]\n\n", commandName(path))
+		fmt.Fprintf(w, "%s\n\nCommands:\n", cmd.summary)
+		printCommandList(w, cmd.subcommands)
+		return
+	}
+
+	fmt.Fprintf(w, "Usage: %s [OPTIONS] %s\n\n", commandName(path), cmd.args)
+	fmt.Fprintln(w, cmd.summary)
+
+	flags := newFlagSet(path)
+	cmd.setup(flags)
+	fmt.Fprintln(w, "\nOptions:")
+	flags.SetOutput(w)
+	flags.PrintDefaults()
+
+	global := newFlagSet(path)
+	(&globals{}).define(global)
+	fmt.Fprintln(w, "\nGlobal options:")
+	global.SetOutput(w)
+	global.PrintDefaults()
+}
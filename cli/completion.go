@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+var completionCommand = &command{
+	name:    "completion",
+	args:    "<bash|zsh>",
+	summary: "Print a shell completion script.",
+	setup: func(flags *flag.FlagSet) runFunc {
+		return func(ctx context.Context, g *globals, args []string) error {
+			if err := requireArgs(args, 1); err != nil {
+				return err
+			}
+
+			switch args[0] {
+			case "bash":
+				printBashCompletion(os.Stdout)
+			case "zsh":
+				fmt.Fprintln(os.Stdout, "autoload -U +X bashcompinit && bashcompinit")
+				printBashCompletion(os.Stdout)
+			default:
+				return fmt.Errorf("unsupported shell: %s", args[0])
+			}
+			return nil
+		}
+	},
+}
+
+// printBashCompletion prints a script completing commands and flags. Paths are completed by the shell.
+func printBashCompletion(w io.Writer) {
+	fmt.Fprintf(w, "_%s_completion() {\n", programName)
+	fmt.Fprintln(w, `    local cur="${COMP_WORDS[COMP_CWORD]}"`)
+	fmt.Fprintln(w, `    local words=""`)
+	fmt.Fprintln(w, `    local i command=""`)
+	fmt.Fprintln(w, `    for ((i = 1; i < COMP_CWORD; i++)); do`)
+	fmt.Fprintln(w, `        [[ "${COMP_WORDS[i]}" == -* ]] || command="${command} ${COMP_WORDS[i]}"`)
+	fmt.Fprintln(w, `    done`)
+	fmt.Fprintln(w, `    case "${command# }" in`)
+
+	globalFlags := flagNames(func(flags *flag.FlagSet) { (&globals{}).define(flags) })
+	fmt.Fprintf(w, "    \"\") words=\"%s\" ;;\n", strings.Join(append(commandNames(commands), globalFlags...), " "))
+	printCommandCompletions(w, nil, commands, globalFlags)
+
+	fmt.Fprintln(w, `    *) COMPREPLY=($(compgen -f -- "${cur}")); return ;;`)
+	fmt.Fprintln(w, `    esac`)
+	fmt.Fprintln(w, `    COMPREPLY=($(compgen -W "${words}" -- "${cur}"))`)
+	fmt.Fprintln(w, `}`)
+	fmt.Fprintf(w, "complete -o default -F _%s_completion %s\n", programName, programName)
+}
+
+func printCommandCompletions(w io.Writer, path []*command, candidates []*command, globalFlags []string) {
+	for _, cmd := range candidates {
+		cmdPath := append(append([]*command{}, path...), cmd)
+		name := strings.TrimPrefix(commandName(cmdPath), programName+" ")
+
+		if len(cmd.subcommands) > 0 {
+			fmt.Fprintf(w, "    \"%s\") words=\"%s\" ;;\n", name, strings.Join(commandNames(cmd.subcommands), " "))
+			printCommandCompletions(w, cmdPath, cmd.subcommands, globalFlags)
+			continue
+		}
+
+		words := flagNames(func(flags *flag.FlagSet) { cmd.setup(flags) })
+		switch cmd.name {
+		case "help":
+			words = append(words, commandNames(commands)...)
+		case "completion":
+			words = append(words, "bash", "zsh")
+		}
+		fmt.Fprintf(w, "    \"%s\"*) words=\"%s\" ;;\n", name, strings.Join(append(words, globalFlags...), " "))
+	}
+}
+
+func commandNames(candidates []*command) []string {
+	names := []string{}
+	for _, cmd := range candidates {
+		names = append(names, cmd.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func flagNames(define func(flags *flag.FlagSet)) []string {
+	flags := flag.NewFlagSet(programName, flag.ContinueOnError)
+	define(flags)
+
+	names := []string{}
+	flags.VisitAll(func(f *flag.Flag) {
+		names = append(names, "-"+f.Name)
+	})
+	return names
+}
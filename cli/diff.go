@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mboye/kopi/differ"
+	"github.com/mboye/kopi/index"
+	"github.com/mboye/kopi/model"
+	log "github.com/sirupsen/logrus"
+)
+
+var diffCommand = &command{
+	name:    "diff",
+	args:    "<index a> <index b>",
+	summary: "Mark files of index b, which have changed since index a, as modified.",
+	setup: func(flags *flag.FlagSet) runFunc {
+		compareContent := flags.Bool("content-hash", false, "Mark files with changed content hashes as modified, even if their metadata is unchanged.")
+		compareInode := flags.Bool("inode", false, "Mark files with changed inode numbers as modified.")
+		compareChangeTime := flags.Bool("ctime", false, "Mark files with changed status change time as metadata modified. Their blocks are reused.")
+
+		return func(ctx context.Context, g *globals, args []string) error {
+			if err := requireArgs(args, 2); err != nil {
+				return err
+			}
+
+			pathA := args[0]
+			pathB := args[1]
+
+			log.WithFields(log.Fields{"index_a": pathA, "index_b": pathB}).Info("Diffing indices")
+
+			var indexA, indexB index.Index
+			var err error
+			if indexA, err = loadIndex(pathA); err != nil {
+				return err
+			}
+			log.WithField("size", indexA.Size()).Info("Loaded index A")
+
+			if indexB, err = loadIndex(pathB); err != nil {
+				return err
+			}
+			log.WithField("size", indexB.Size()).Info("Loaded index B")
+
+			differ.MarkChanges(indexA, indexB, differ.Options{
+				CompareContent:    *compareContent,
+				CompareInode:      *compareInode,
+				CompareChangeTime: *compareChangeTime})
+			indexB.Print()
+			return nil
+		}
+	},
+}
+
+func loadIndex(path string) (index.Index, error) {
+	log.Debugf("Loading index: %s", path)
+	inputFile, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index: %s", err.Error())
+	}
+	defer inputFile.Close()
+	decoder := json.NewDecoder(inputFile)
+
+	index := index.New()
+	for decoder.More() {
+		file := &model.File{}
+		if err = decoder.Decode(file); err != nil {
+			return nil, fmt.Errorf("failed to decode file: %s", err.Error())
+		}
+
+		file.Modified = false
+		file.MetadataModified = false
+		if err := index.Add(file); err != nil {
+			return nil, err
+		}
+	}
+	return index, nil
+}
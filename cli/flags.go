@@ -0,0 +1,15 @@
+package cli
+
+import "strings"
+
+// stringList is a flag which may be given more than once.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
@@ -0,0 +1,37 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var helpCommand = &command{
+	name:    "help",
+	args:    "[command]",
+	summary: "Show help for a command.",
+	setup: func(flags *flag.FlagSet) runFunc {
+		return func(ctx context.Context, g *globals, args []string) error {
+			if len(args) == 0 {
+				printProgramUsage()
+				return nil
+			}
+
+			path := []*command{}
+			candidates := commands
+			for _, name := range args {
+				cmd := findCommand(candidates, name)
+				if cmd == nil {
+					return fmt.Errorf("unknown command: %s", strings.Join(args, " "))
+				}
+				path = append(path, cmd)
+				candidates = cmd.subcommands
+			}
+
+			printCommandUsage(os.Stdout, path)
+			return nil
+		}
+	},
+}
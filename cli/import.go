@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mboye/kopi/importer"
+)
+
+var importCommand = &command{
+	name:    "import",
+	args:    "<tar archive> <backup dir>",
+	summary: "Store the files of a tar archive and write a new manifest without unpacking it. Reads STDIN if the archive is -.",
+	setup: func(flags *flag.FlagSet) runFunc {
+		maxBlockSize := flags.Int64("maxBlockSize", 1024*1024*10, "Split files into blocks of this size")
+		encrypt := flags.Bool("encrypt", false, "Require an encrypted repository. Encryption is enabled by kopi init.")
+		description := flags.String("description", "", "Manifest description e.g. vendor data 2019/1")
+
+		return func(ctx context.Context, g *globals, args []string) error {
+			backupDir, args, err := g.takeRepository(args, -1, 1)
+			if err != nil {
+				return err
+			}
+
+			if err := requireArgs(args, 1); err != nil {
+				return err
+			}
+
+			var archive io.Reader = os.Stdin
+			if args[0] != "-" {
+				archiveFile, err := os.Open(args[0])
+				if err != nil {
+					return fmt.Errorf("failed to open archive: %s", err.Error())
+				}
+				defer archiveFile.Close()
+				archive = archiveFile
+			}
+
+			i, err := importer.New(archive, backupDir, importer.Options{
+				MaxBlockSize: *maxBlockSize,
+				Encrypt:      *encrypt,
+				Description:  *description})
+			if err != nil {
+				return err
+			}
+
+			return i.Execute(ctx)
+		}
+	},
+}
@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"context"
+	"flag"
+
+	"github.com/mboye/kopi/scanner"
+)
+
+var indexCommand = &command{
+	name:    "index",
+	args:    "<path>",
+	summary: "Index a path and print index lines on STDOUT.",
+	setup: func(flags *flag.FlagSet) runFunc {
+		recursive := flags.Bool("recursive", true, "Index path recursively")
+		initial := flags.Bool("init", false, "Initial index. Mark all files as modified.")
+		withProgress := flags.Bool("progress", true, "Print indexing progress.")
+		oneFileSystem := flags.Bool("one-file-system", false, "Do not cross file system boundaries.")
+		listMountPoints := flags.Bool("list-mount-points", false, "Index skipped mount points as empty directories.")
+		workers := flags.Int("workers", 1, "Number of directories to read in parallel.")
+		sorted := flags.Bool("sorted", false, "Print files in sorted order when using multiple workers.")
+		contentHash := flags.Bool("content-hash", false, "Hash file contents to detect changes that preserve metadata.")
+		contentHashSample := flags.Int("content-hash-sample", 100, "Percentage of files to hash per run.")
+
+		return func(ctx context.Context, g *globals, args []string) error {
+			if err := requireArgs(args, 1); err != nil {
+				return err
+			}
+
+			s, err := scanner.New(args[0], scanner.Options{
+				Recursive:         *recursive,
+				Initial:           *initial,
+				WithProgress:      *withProgress,
+				OneFileSystem:     *oneFileSystem,
+				ListMountPoints:   *listMountPoints,
+				Workers:           *workers,
+				Sorted:            *sorted,
+				ContentHash:       *contentHash,
+				ContentHashSample: *contentHashSample})
+			if err != nil {
+				return err
+			}
+
+			return s.Execute(ctx)
+		}
+	},
+}
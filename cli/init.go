@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/mboye/kopi/repository"
+	"github.com/mboye/kopi/security"
+)
+
+var initCommand = &command{
+	name:    "init",
+	args:    "<repository dir>",
+	summary: "Create a repository. Its format and encryption cannot be changed afterwards.",
+	setup: func(flags *flag.FlagSet) runFunc {
+		encrypt := flags.Bool("encrypt", false, "Encrypt blocks and manifests using AES-256")
+		hash := flags.String("hash", security.HashHMACSHA256,
+			fmt.Sprintf("Hash algorithm of block IDs: %s", strings.Join(security.HashAlgorithms, ", ")))
+		padding := flags.String("padding", security.PaddingPadme,
+			fmt.Sprintf("Padding of encrypted blocks and manifests: %s", strings.Join(security.PaddingSchemes, ", ")))
+		kdfParams := defineKDFFlags(flags)
+
+		return func(ctx context.Context, g *globals, args []string) error {
+			dir, args, err := g.takeRepository(args, 0, 0)
+			if err != nil {
+				return err
+			}
+
+			if err := requireArgs(args, 0); err != nil {
+				return err
+			}
+
+			config := repository.DefaultConfig(*encrypt)
+			config.Hash = *hash
+			if config.Encrypted() {
+				config.Padding = *padding
+				if config.KDF, err = kdfParams(); err != nil {
+					return err
+				}
+			}
+
+			return repository.Init(dir, config)
+		}
+	},
+}
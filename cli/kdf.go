@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/mboye/kopi/security"
+)
+
+// defineKDFFlags defines the key derivation flags and returns a function which builds the chosen parameters.
+// Parameters which are not given keep the defaults of the algorithm.
+func defineKDFFlags(flags *flag.FlagSet) func() (*security.KDFParams, error) {
+	algorithm := flags.String("kdf", security.KDFArgon2id,
+		fmt.Sprintf("Key derivation function: %s", strings.Join(security.KDFAlgorithms, ", ")))
+	iterations := flags.Int("kdf-iterations", 0, "Passes of argon2id or iterations of pbkdf2-sha1")
+	memory := flags.Uint("kdf-memory", 0, "Memory of argon2id in KiB")
+	parallelism := flags.Uint("kdf-parallelism", 0, "Threads of argon2id or parallelization of scrypt")
+	cost := flags.Int("kdf-cost", 0, "CPU/memory cost of scrypt. Must be a power of two.")
+
+	return func() (*security.KDFParams, error) {
+		params := security.DefaultKDFParams(*algorithm)
+		if params == nil {
+			return nil, fmt.Errorf("unsupported key derivation function: %s", *algorithm)
+		}
+
+		if *iterations > 0 {
+			params.Iterations = *iterations
+		}
+		if *memory > 0 {
+			params.Memory = uint32(*memory)
+		}
+		if *parallelism > 255 {
+			return nil, fmt.Errorf("parallelism must be <= 255")
+		} else if *parallelism > 0 {
+			params.Parallelism = uint8(*parallelism)
+		}
+		if *cost > 0 {
+			params.Cost = *cost
+		}
+		return params, nil
+	}
+}
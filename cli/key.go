@@ -0,0 +1,173 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mboye/kopi/repository"
+	"github.com/mboye/kopi/security"
+	log "github.com/sirupsen/logrus"
+)
+
+var keyCommand = &command{
+	name:        "key",
+	summary:     "Manage the passwords which unlock an encrypted repository.",
+	subcommands: []*command{keyListCommand, keyAddCommand, keyRemoveCommand, keyPasswdCommand, keyMigrateCommand},
+}
+
+var keyListCommand = &command{
+	name:    "list",
+	args:    "<repository dir>",
+	summary: "List the keys of a repository.",
+	setup: func(flags *flag.FlagSet) runFunc {
+		return func(ctx context.Context, g *globals, args []string) error {
+			_, dir, err := loadKeyRepository(g, args)
+			if err != nil {
+				return err
+			}
+
+			slots, err := security.ListKeys(dir)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tCREATED\tKDF\tDESCRIPTION")
+			for _, slot := range slots {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+					slot.ID, slot.Created.Format(time.RFC3339), slot.KDF.Algorithm, slot.Description)
+			}
+			return w.Flush()
+		}
+	},
+}
+
+var keyAddCommand = &command{
+	name:    "add",
+	args:    "<repository dir>",
+	summary: "Add a password. The current password must unlock the repository.",
+	setup: func(flags *flag.FlagSet) runFunc {
+		description := flags.String("description", "", "Key description e.g. recovery key of the finance team")
+		definePasswordFlags(flags, "new-password", security.NewPassword)
+
+		return func(ctx context.Context, g *globals, args []string) error {
+			config, dir, err := loadKeyRepository(g, args)
+			if err != nil {
+				return err
+			}
+
+			slot, err := security.AddKey(dir, config.Config, *description)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println(slot.ID)
+			return nil
+		}
+	},
+}
+
+var keyRemoveCommand = &command{
+	name:    "remove",
+	args:    "<repository dir> <key ID>",
+	summary: "Remove a password. The last password of a repository cannot be removed.",
+	setup: func(flags *flag.FlagSet) runFunc {
+		return func(ctx context.Context, g *globals, args []string) error {
+			dir, args, err := g.takeRepository(args, 0, 1)
+			if err != nil {
+				return err
+			}
+
+			if len(args) != 1 {
+				log.Error("Key ID parameter missing")
+				return errUsage
+			}
+
+			config, err := repository.LoadConfig(dir)
+			if err != nil {
+				return err
+			}
+
+			return security.RemoveKey(dir, config.Config, args[0])
+		}
+	},
+}
+
+var keyPasswdCommand = &command{
+	name:    "passwd",
+	args:    "<repository dir>",
+	summary: "Change the password of the key unlocked by the current password.",
+	setup: func(flags *flag.FlagSet) runFunc {
+		definePasswordFlags(flags, "new-password", security.NewPassword)
+
+		return func(ctx context.Context, g *globals, args []string) error {
+			config, dir, err := loadKeyRepository(g, args)
+			if err != nil {
+				return err
+			}
+
+			slot, err := security.ChangePassword(dir, config.Config)
+			if err != nil {
+				return err
+			}
+
+			log.WithField("id", slot.ID).Info("Changed password")
+			return nil
+		}
+	},
+}
+
+var keyMigrateCommand = &command{
+	name:    "migrate",
+	args:    "<repository dir>",
+	summary: "Re-wrap the key unlocked by the current password using a new key derivation function. Also used for keys added later.",
+	setup: func(flags *flag.FlagSet) runFunc {
+		kdfParams := defineKDFFlags(flags)
+
+		return func(ctx context.Context, g *globals, args []string) error {
+			config, dir, err := loadKeyRepository(g, args)
+			if err != nil {
+				return err
+			}
+
+			params, err := kdfParams()
+			if err != nil {
+				return err
+			}
+
+			slot, err := security.MigrateKey(dir, config.Config, *params)
+			if err != nil {
+				return err
+			}
+
+			config.KDF = params
+			if err := repository.SaveConfig(dir, config); err != nil {
+				return err
+			}
+
+			log.WithFields(log.Fields{"id": slot.ID, "kdf": params.Algorithm}).Info("Migrated key")
+			return nil
+		}
+	},
+}
+
+func loadKeyRepository(g *globals, args []string) (*repository.Config, string, error) {
+	dir, args, err := g.takeRepository(args, 0, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := requireArgs(args, 0); err != nil {
+		return nil, "", err
+	}
+
+	config, err := repository.LoadConfig(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	return config, dir, nil
+}
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"context"
+	"flag"
+
+	"github.com/mboye/kopi/manifest"
+	log "github.com/sirupsen/logrus"
+)
+
+var manifestCommand = &command{
+	name:        "manifest",
+	summary:     "Read and write manifests.",
+	subcommands: []*command{manifestWriteCommand, manifestReadCommand},
+}
+
+var manifestWriteCommand = &command{
+	name:    "write",
+	args:    "<destination dir>",
+	summary: "Write a manifest. Pass index lines on STDIN.",
+	setup: func(flags *flag.FlagSet) runFunc {
+		encrypt := flags.Bool("encrypt", false, "Require an encrypted repository. Encryption is enabled by kopi init.")
+		description := flags.String("description", "", "Manifest description e.g. monthly backup 2019/1")
+
+		return func(ctx context.Context, g *globals, args []string) error {
+			backupDir, args, err := g.takeRepository(args, 0, 0)
+			if err != nil {
+				return err
+			}
+
+			if err := requireArgs(args, 0); err != nil {
+				return err
+			}
+
+			writer, err := manifest.NewWriter(backupDir, *encrypt, *description)
+			if err != nil {
+				return err
+			}
+
+			return writer.Execute(ctx)
+		}
+	},
+}
+
+var manifestReadCommand = &command{
+	name:    "read",
+	args:    "<source dir> <manifest ID>",
+	summary: "Read a manifest and print its index lines on STDOUT.",
+	setup: func(flags *flag.FlagSet) runFunc {
+		decrypt := flags.Bool("decrypt", false, "Require an encrypted repository. Decryption is enabled by the repository config.")
+
+		return func(ctx context.Context, g *globals, args []string) error {
+			backupDir, args, err := g.takeRepository(args, 0, 1)
+			if err != nil {
+				return err
+			}
+
+			if len(args) != 1 {
+				log.Error("Manifest ID parameter missing")
+				return errUsage
+			}
+
+			reader, err := manifest.NewReader(backupDir, *decrypt, args[0])
+			if err != nil {
+				return err
+			}
+
+			return reader.Execute(ctx)
+		}
+	},
+}
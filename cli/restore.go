@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/mboye/kopi/restorer"
+	log "github.com/sirupsen/logrus"
+)
+
+var restoreCommand = &command{
+	name:    "restore",
+	args:    "<store dir> <destination dir | archive>",
+	summary: "Restore files from blocks. Pass index lines on STDIN.",
+	setup: func(flags *flag.FlagSet) runFunc {
+		dryRun := flags.Bool("dry-run", false, "Dry run. Only verify that index is restorable.")
+		decrypt := flags.Bool("decrypt", false, "Require an encrypted repository. Decryption is enabled by the repository config.")
+		progressInterval := flags.Int("progress", 10, "Progres printing interval in seconds. An interval of zero disables printing.")
+		var include, exclude stringList
+		flags.Var(&include, "include", "Restore only files matching this glob pattern. Patterns without a slash match base names. May be repeated.")
+		flags.Var(&exclude, "exclude", "Skip files matching this glob pattern. Takes precedence over -include. May be repeated.")
+		pathPrefix := flags.String("path-prefix", "", "Restore only this path and the files below it.")
+		stripComponents := flags.Int("strip-components", 0, "Remove this many leading components from restored paths.")
+		targetPrefix := flags.String("target-prefix", "", "Prepend this path to restored paths, below the destination dir.")
+		overwrite := flags.String("overwrite", restorer.OverwriteNever,
+			fmt.Sprintf("Policy for existing files: %s. Files are different if their size, modification time or content differs.",
+				strings.Join(restorer.OverwritePolicies, ", ")))
+		archive := flags.String("archive", "",
+			fmt.Sprintf("Write files into an archive at the destination instead of restoring them: %s. A destination of %s writes to STDOUT.",
+				strings.Join(restorer.ArchiveFormats, ", "), restorer.ArchiveStdout))
+		delta := flags.Bool("delta", false, "Only fetch the blocks of existing files which differ, and patch them in place. Requires -overwrite.")
+
+		return func(ctx context.Context, g *globals, args []string) error {
+			inputDir, args, err := g.takeRepository(args, 0, 1)
+			if err != nil {
+				return err
+			}
+
+			if err := requireArgs(args, 1); err != nil {
+				return err
+			}
+
+			if *dryRun {
+				log.Info("Dry run mode enabled")
+			}
+
+			r, err := restorer.New(inputDir, args[0], restorer.Options{
+				DryRun:           *dryRun,
+				Decrypt:          *decrypt,
+				ProgressInterval: *progressInterval,
+				Include:          include,
+				Exclude:          exclude,
+				PathPrefix:       *pathPrefix,
+				StripComponents:  *stripComponents,
+				TargetPrefix:     *targetPrefix,
+				Overwrite:        *overwrite,
+				Delta:            *delta,
+				Archive:          *archive})
+			if err != nil {
+				return err
+			}
+
+			return r.Execute(ctx)
+		}
+	},
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+
+	"github.com/mboye/kopi/storer"
+)
+
+var storeCommand = &command{
+	name:    "store",
+	args:    "<destination dir>",
+	summary: "Store modified files as blocks. Pass index lines on STDIN, or a byte stream with -stdin-name.",
+	setup: func(flags *flag.FlagSet) runFunc {
+		maxBlockSize := flags.Int64("maxBlockSize", 1024*1024*10, "Split files into blocks of this size")
+		encrypt := flags.Bool("encrypt", false, "Require an encrypted repository. Encryption is enabled by kopi init.")
+		resume := flags.Bool("resume", false, "Continue an interrupted run. Files stored by it are not read again.")
+		stdinName := flags.String("stdin-name", "", "Store the bytes on STDIN as a single file at this path, e.g. a database dump.")
+		progressInterval := flags.Uint("progress", 10, "Progres printing interval in seconds. An interval of zero disables printing.")
+
+		return func(ctx context.Context, g *globals, args []string) error {
+			outputDir, args, err := g.takeRepository(args, 0, 0)
+			if err != nil {
+				return err
+			}
+
+			if err := requireArgs(args, 0); err != nil {
+				return err
+			}
+
+			if *stdinName != "" {
+				if *resume {
+					return errors.New("cannot resume storing a stream")
+				}
+
+				s, err := storer.NewStream(outputDir, *stdinName, *maxBlockSize, *encrypt)
+				if err != nil {
+					return err
+				}
+				return s.Execute(ctx)
+			}
+
+			s, err := storer.New(outputDir, *maxBlockSize, *encrypt, true, *resume, *progressInterval)
+			if err != nil {
+				return err
+			}
+
+			return s.Execute(ctx)
+		}
+	},
+}
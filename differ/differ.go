@@ -0,0 +1,79 @@
+package differ
+
+import (
+	"github.com/mboye/kopi/index"
+	"github.com/mboye/kopi/model"
+	log "github.com/sirupsen/logrus"
+)
+
+// Options selects which changes, besides size, modification time and mode, mark a file as modified.
+type Options struct {
+	CompareContent    bool
+	CompareInode      bool
+	CompareChangeTime bool
+}
+
+// Summary counts the changes found while diffing.
+type Summary struct {
+	ModifiedFiles         int64
+	ContentModifiedFiles  int64
+	MetadataModifiedFiles int64
+}
+
+// MarkChange compares a file with its previous version and marks it as modified if it has changed.
+// Blocks of unmodified files are copied from the previous version.
+func MarkChange(previous index.Index, file *model.File, options Options, summary *Summary) {
+	previousFile := previous.Find(file.OSPath())
+	if previousFile == nil {
+		file.Modified = true
+		summary.ModifiedFiles++
+		return
+	}
+
+	if !model.FilesEqual(previousFile, file) {
+		file.Modified = true
+		summary.ModifiedFiles++
+	} else if options.CompareContent && model.ContentChanged(previousFile, file) {
+		log.WithField("path", file.Path).Warn("Content changed without metadata change")
+		file.Modified = true
+		summary.ModifiedFiles++
+		summary.ContentModifiedFiles++
+	} else if options.CompareInode && model.InodeChanged(previousFile, file) {
+		log.WithField("path", file.Path).Debug("File replaced")
+		file.Modified = true
+		summary.ModifiedFiles++
+	} else {
+		// Preserve blocks and holes of unmodified file
+		file.Blocks = previousFile.Blocks
+		file.Holes = previousFile.Holes
+
+		// Carry the content hash forward for files which were not hashed in this run
+		if file.ContentHash == "" {
+			file.ContentHash = previousFile.ContentHash
+		}
+
+		if options.CompareChangeTime && model.ChangeTimeChanged(previousFile, file) {
+			file.MetadataModified = true
+			summary.MetadataModifiedFiles++
+		}
+	}
+}
+
+// MarkChanges marks the files of index b, which have changed since index a.
+func MarkChanges(a, b index.Index, options Options) Summary {
+	summary := Summary{}
+	b.Walk(func(path string, file *model.File) error {
+		MarkChange(a, file, options, &summary)
+		return nil
+	})
+
+	summary.Log()
+	return summary
+}
+
+func (s Summary) Log() {
+	log.WithFields(log.Fields{
+		"modified_files":          s.ModifiedFiles,
+		"content_modified_files":  s.ContentModifiedFiles,
+		"metadata_modified_files": s.MetadataModifiedFiles}).Info("Diffing completed")
+}
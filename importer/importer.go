@@ -0,0 +1,76 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/mboye/kopi/manifest"
+	"github.com/mboye/kopi/model"
+	"github.com/mboye/kopi/repository"
+	"github.com/mboye/kopi/stage"
+	log "github.com/sirupsen/logrus"
+)
+
+// Options configures an import.
+type Options struct {
+	MaxBlockSize int64
+	Encrypt      bool
+	Description  string
+}
+
+type importer struct {
+	archive   io.Reader
+	backupDir string
+	options   Options
+}
+
+var _ stage.Stage = (*importer)(nil)
+
+// New creates a stage which stores the files of a tar archive read from archive and writes a manifest of them
+// to backupDir. Files are stored straight from the stream, without unpacking the archive.
+func New(archive io.Reader, backupDir string, options Options) (stage.Stage, error) {
+	if backupDir == "" {
+		return nil, errors.New("backup dir cannot be empty")
+	}
+
+	if options.MaxBlockSize < 1 {
+		return nil, errors.New("max block size must be > 0")
+	}
+
+	return &importer{archive, backupDir, options}, nil
+}
+
+func (i *importer) Execute(ctx context.Context) error {
+	startTime := time.Now()
+
+	if _, err := repository.LoadConfig(i.backupDir); err != nil {
+		return err
+	}
+
+	var fileCount, byteCount int64
+	reader := stage.Observe(newTarReader(i.archive, i.backupDir, i.options.Encrypt, i.options.MaxBlockSize),
+		func(file *model.File) {
+			fileCount++
+			byteCount += file.Size
+		})
+
+	writer, err := manifest.NewWriter(i.backupDir, i.options.Encrypt, i.options.Description)
+	if err != nil {
+		return err
+	}
+
+	if err := stage.RunPipeline(ctx, reader, nil, writer); err != nil {
+		return fmt.Errorf("import failed: %s", err.Error())
+	}
+
+	log.WithFields(log.Fields{
+		"manifest":     writer.ID(),
+		"files":        fileCount,
+		"bytes":        humanize.Bytes(uint64(byteCount)),
+		"elapsed_time": time.Since(startTime).Round(time.Second).String()}).Info("Import completed")
+	return nil
+}
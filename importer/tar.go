@@ -0,0 +1,203 @@
+package importer
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/klauspost/compress/zstd"
+	"github.com/mboye/kopi/model"
+	"github.com/mboye/kopi/outputhandler"
+	"github.com/mboye/kopi/repository"
+	"github.com/mboye/kopi/security"
+	"github.com/mboye/kopi/stage"
+	"github.com/mboye/kopi/storer"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+type tarReader struct {
+	input        io.Reader
+	outputDir    string
+	encrypt      bool
+	maxBlockSize int64
+	output       outputhandler.OutputHandler
+}
+
+var _ stage.Producer = (*tarReader)(nil)
+
+// newTarReader creates a stage which stores the files of a tar stream as blocks and outputs them.
+// Gzip and zstd compressed streams are detected.
+func newTarReader(input io.Reader, outputDir string, encrypt bool, maxBlockSize int64) stage.Producer {
+	return &tarReader{input, outputDir, encrypt, maxBlockSize, outputhandler.Stdout}
+}
+
+func (r *tarReader) SetOutput(output outputhandler.OutputHandler) {
+	r.output = output
+}
+
+func (r *tarReader) Execute(ctx context.Context) error {
+	securityContext, err := repository.NewSecurityContext(r.outputDir, r.encrypt)
+	if err != nil {
+		return fmt.Errorf("failed to create security context: %s", err.Error())
+	}
+
+	stream, err := decompress(r.input)
+	if err != nil {
+		return err
+	}
+
+	// Blocks of regular files by path, since hard links refer to files earlier in the stream
+	stored := map[string]*model.File{}
+	// Paths of symbolic links, since members below them would be restored through the links
+	symlinks := map[string]bool{}
+
+	var fileCount, byteCount int64
+	archive := tar.NewReader(stream)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read archive: %s", err.Error())
+		}
+
+		file, err := r.importEntry(ctx, header, archive, stored, symlinks, securityContext)
+		if err != nil {
+			return err
+		} else if file == nil {
+			continue
+		}
+
+		if err := r.output.Handle(file); err != nil {
+			return err
+		}
+		fileCount++
+		byteCount += file.Size
+	}
+
+	log.WithFields(log.Fields{
+		"files": fileCount,
+		"bytes": humanize.Bytes(uint64(byteCount))}).Info("Read archive")
+	return nil
+}
+
+// importEntry returns the file described by a tar header, or nil if the entry cannot be represented.
+func (r *tarReader) importEntry(ctx context.Context, header *tar.Header, data io.Reader, stored map[string]*model.File,
+	symlinks map[string]bool, securityContext *security.Context) (*model.File, error) {
+	name, safe := memberName(header.Name)
+	logger := log.WithField("path", header.Name)
+	if !safe {
+		logger.Warn("Ignoring entry outside of the archive root")
+		return nil, nil
+	} else if name == "." {
+		return nil, nil
+	} else if belowSymlink(name, symlinks) {
+		logger.Warn("Ignoring entry below a symbolic link")
+		return nil, nil
+	}
+
+	file := &model.File{
+		Mode:         header.FileInfo().Mode(),
+		ModifiedTime: header.ModTime.UTC(),
+		UID:          header.Uid,
+		GID:          header.Gid,
+		Modified:     true}
+	file.SetPath(filepath.FromSlash(name))
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		logger.Debug("Importing directory")
+	case tar.TypeSymlink:
+		logger.Debug("Importing symbolic link")
+		file.LinkTarget = header.Linkname
+		symlinks[name] = true
+	case tar.TypeLink:
+		targetName, safe := memberName(header.Linkname)
+		target, found := stored[targetName]
+		if !safe {
+			logger.WithField("target", header.Linkname).Warn("Ignoring hard link outside of the archive root")
+			return nil, nil
+		} else if !found {
+			logger.WithField("target", header.Linkname).Warn("Ignoring hard link to unknown file")
+			return nil, nil
+		}
+		logger.Debug("Importing hard link")
+		file.Size = target.Size
+		file.Blocks = target.Blocks
+	case tar.TypeReg:
+		logger.WithField("size", header.Size).Debug("Importing file")
+		file.Size = header.Size
+		err := storer.StoreBlocks(ctx, data, file, 0, header.Size, r.outputDir, securityContext, r.maxBlockSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store %s: %s", name, err.Error())
+		}
+		stored[name] = file
+	default:
+		logger.WithField("type", string(header.Typeflag)).Warn("Ignoring unsupported entry")
+		return nil, nil
+	}
+
+	return file, nil
+}
+
+// memberName returns the path of an archive member relative to the archive root. Like GNU tar, leading
+// slashes are removed and names which leave the root are rejected, since restoring them would write
+// outside the destination.
+func memberName(name string) (string, bool) {
+	name = path.Clean(strings.TrimLeft(name, "/"))
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return "", false
+	}
+	return name, true
+}
+
+// belowSymlink reports whether a parent directory of a member is a symbolic link from the archive.
+func belowSymlink(name string, symlinks map[string]bool) bool {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if symlinks[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// decompress detects compressed streams by their magic numbers.
+func decompress(input io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(input)
+	magic, err := buffered.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read archive: %s", err.Error())
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		log.Debug("Detected gzip compressed archive")
+		return gzip.NewReader(buffered)
+	case bytes.HasPrefix(magic, zstdMagic):
+		log.Debug("Detected zstd compressed archive")
+		decoder, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return buffered, nil
+	}
+}
@@ -18,11 +18,11 @@ func New() Index {
 }
 
 func (t *simpleIndex) Add(file *model.File) error {
-	if t.Find(file.Path) != nil {
+	if t.Find(file.OSPath()) != nil {
 		return errors.New("file path already in Index")
 	}
 
-	t.files[file.Path] = file
+	t.files[file.OSPath()] = file
 	return nil
 }
 
@@ -42,8 +42,8 @@ func (t *simpleIndex) Print() {
 	}
 
 	filePathLess := func(a, b int) bool {
-		pathA := sortedFiles[a].Path
-		pathB := sortedFiles[b].Path
+		pathA := sortedFiles[a].OSPath()
+		pathB := sortedFiles[b].OSPath()
 		return pathA < pathB
 	}
 
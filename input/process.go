@@ -1,13 +1,12 @@
 package input
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"math"
 	"os"
-	"os/signal"
 	"sync/atomic"
-	"syscall"
 	"time"
 
 	"github.com/dustin/go-humanize"
@@ -15,19 +14,44 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// readAhead is the number of files a source is read ahead of the handler which processes them
+const readAhead = 1000
+
 type FileHandlerFunc func(file *model.File) error
 
-func ProcessFiles(handler FileHandlerFunc) error {
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+// Source passes files to a handler until the input is exhausted or ctx is cancelled.
+type Source func(ctx context.Context, handler FileHandlerFunc) error
+
+// Stdin reads JSON encoded files from STDIN.
+var Stdin Source = ProcessFiles
+
+// FromChannel reads files from a channel, which is filled by another stage in the same process.
+// Once the channel is closed, the result of the producing stage is read from result, so failures
+// are passed on instead of being mistaken for the end of the input.
+func FromChannel(files <-chan *model.File, result <-chan error) Source {
+	return func(ctx context.Context, handler FileHandlerFunc) error {
+		for {
+			select {
+			case file, ok := <-files:
+				if !ok {
+					return <-result
+				}
+				if err := handler(file); err != nil {
+					return err
+				}
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
 
+func ProcessFiles(ctx context.Context, handler FileHandlerFunc) error {
 	decoder := json.NewDecoder(os.Stdin)
 
 	for decoder.More() {
-		select {
-		case sig := <-signals:
-			log.Fatalf("Received signal: %s", sig.String())
-		default:
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
 		file := &model.File{}
@@ -40,47 +64,63 @@ func ProcessFiles(handler FileHandlerFunc) error {
 		}
 
 	}
-	return nil
+	// Input which ends after cancellation may be incomplete, e.g. if the previous stage was interrupted too
+	return ctx.Err()
 }
 
-func ProcessFilesWithProgress(handler FileHandlerFunc, interval uint) error {
-	files := []*model.File{}
-	var maxFiles, maxBytes int64
-	var filesProcessed, bytesProcessed int64
-	startTime := time.Now()
+func ProcessFilesWithProgress(ctx context.Context, handler FileHandlerFunc, interval uint) error {
+	return ProcessSourceWithProgress(ctx, Stdin, handler, interval)
+}
 
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+// ProcessSourceWithProgress passes files from source to handler as they arrive. The source is read ahead of
+// the handler by up to readAhead files, and progress is reported against the files read so far, which is the
+// total once the source is exhausted. Processing stops between files once ctx is cancelled.
+func ProcessSourceWithProgress(ctx context.Context, source Source, handler FileHandlerFunc, interval uint) error {
+	var filesRead, bytesRead, filesProcessed, bytesProcessed int64
+	var sourceDone int32
+	startTime := time.Now()
 
-	addToSummary := func(f *model.File) error {
-		files = append(files, f)
-		maxFiles++
-		maxBytes += f.Size
-		return nil
-	}
+	readCtx, stopReading := context.WithCancel(ctx)
+	defer stopReading()
 
-	if err := ProcessFiles(addToSummary); err != nil {
-		return err
+	files := make(chan *model.File, readAhead)
+	result := make(chan error, 1)
+	go func() {
+		err := source(readCtx, func(file *model.File) error {
+			atomic.AddInt64(&filesRead, 1)
+			atomic.AddInt64(&bytesRead, file.Size)
+			select {
+			case files <- file:
+				return nil
+			case <-readCtx.Done():
+				return readCtx.Err()
+			}
+		})
+		atomic.StoreInt32(&sourceDone, 1)
+		close(files)
+		result <- err
+	}()
+
+	reportProgress := func() {
+		printProgress(atomic.LoadInt64(&filesRead), atomic.LoadInt64(&bytesRead),
+			atomic.LoadInt64(&filesProcessed), atomic.LoadInt64(&bytesProcessed),
+			startTime, atomic.LoadInt32(&sourceDone) == 1)
 	}
 
 	progressPrinter := func(stop chan struct{}) {
 		ticker := time.NewTicker(time.Duration(interval * 1e9))
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
-				filesProcessedSnapshot := atomic.LoadInt64(&filesProcessed)
-				bytesProcessedSnapshot := atomic.LoadInt64(&bytesProcessed)
-				printProgress(maxFiles, maxBytes, filesProcessedSnapshot, bytesProcessedSnapshot, startTime)
+				reportProgress()
 			case <-stop:
 				return
 			}
 		}
-
 	}
 
 	if interval > 0 {
-		printProgress(maxFiles, maxBytes, filesProcessed, bytesProcessed, startTime)
-
 		stop := make(chan struct{}, 1)
 		go progressPrinter(stop)
 		defer func() {
@@ -88,33 +128,46 @@ func ProcessFilesWithProgress(handler FileHandlerFunc, interval uint) error {
 		}()
 	}
 
-	for _, file := range files {
-		select {
-		case sig := <-signals:
-			log.Fatalf("Received signal: %s", sig.String())
-		default:
-		}
-
+	processFile := func(file *model.File) error {
 		if err := handler(file); err != nil {
 			return err
 		}
 
 		atomic.AddInt64(&filesProcessed, 1)
 		atomic.AddInt64(&bytesProcessed, file.Size)
+		return nil
 	}
-	printProgress(maxFiles, maxBytes, filesProcessed, bytesProcessed, startTime)
 
+	if err := FromChannel(files, result)(ctx, processFile); err != nil {
+		return err
+	} else if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	reportProgress()
 	return nil
 }
 
-func printProgress(maxFiles, maxBytes, filesProcessed, bytesProcessed int64, startTime time.Time) {
+// printProgress reports the files processed out of the files read. Percentages and the remaining time are
+// only known once all files have been read.
+func printProgress(maxFiles, maxBytes, filesProcessed, bytesProcessed int64, startTime time.Time, complete bool) {
 	if bytesProcessed > maxBytes {
-		bytesProcessed = maxFiles
+		bytesProcessed = maxBytes
+	}
+
+	elapsedTime := time.Now().Sub(startTime).Round(time.Second)
+	if !complete {
+		log.WithFields(log.Fields{
+			"file_progress": fmt.Sprintf("%d / %d+", filesProcessed, maxFiles),
+			"byte_progress": fmt.Sprintf("%s / %s+",
+				humanize.Bytes(uint64(bytesProcessed)), humanize.Bytes(uint64(maxBytes))),
+			"elapsed_time": elapsedTime.String(),
+		}).Info("Progress")
+		return
 	}
 
 	fileProgress := 100.0 * float32(filesProcessed) / float32(maxFiles)
 	byteProgress := 100.0 * float32(bytesProcessed) / float32(maxBytes)
-	elapsedTime := time.Now().Sub(startTime).Round(time.Second)
 
 	fileRate := float64(filesProcessed) / elapsedTime.Seconds()
 	byteRate := float64(bytesProcessed) / elapsedTime.Seconds()
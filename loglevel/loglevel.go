@@ -1,11 +1,18 @@
 package util
 
 import (
+	"fmt"
 	"os"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// EnvVar is the environment variable holding the log level.
+const EnvVar = "KOPI_LOG_LEVEL"
+
+// Levels lists the supported log levels.
+var Levels = []string{"DEBUG", "INFO", "WARN", "ERROR", "FATAL", "PANIC"}
+
 func init() {
 	SetLogLevel()
 }
@@ -13,22 +20,30 @@ func init() {
 // SetLogLevel detects and sets the log level from the environment variable KOPI_LOG_LEVEL.
 // The following values are supported: DEBUG, INFO, WARN, ERROR, FATAL, and PANIC.
 func SetLogLevel() {
-	if level, exists := os.LookupEnv("KOPI_LOG_LEVEL"); exists {
-		switch level {
-		case "DEBUG":
-			log.SetLevel(log.DebugLevel)
-		case "INFO":
-			log.SetLevel(log.InfoLevel)
-		case "WARN":
-			log.SetLevel(log.WarnLevel)
-		case "ERROR":
-			log.SetLevel(log.ErrorLevel)
-		case "FATAL":
-			log.SetLevel(log.FatalLevel)
-		case "PANIC":
-			log.SetLevel(log.PanicLevel)
-		default:
+	if level, exists := os.LookupEnv(EnvVar); exists {
+		if err := Set(level); err != nil {
 			log.Warnf("Unknown log level: %s", level)
 		}
 	}
 }
+
+// Set sets the log level to one of the supported levels.
+func Set(level string) error {
+	switch level {
+	case "DEBUG":
+		log.SetLevel(log.DebugLevel)
+	case "INFO":
+		log.SetLevel(log.InfoLevel)
+	case "WARN":
+		log.SetLevel(log.WarnLevel)
+	case "ERROR":
+		log.SetLevel(log.ErrorLevel)
+	case "FATAL":
+		log.SetLevel(log.FatalLevel)
+	case "PANIC":
+		log.SetLevel(log.PanicLevel)
+	default:
+		return fmt.Errorf("unknown log level: %s", level)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package manifest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Latest returns the ID of the most recently written manifest in a backup directory.
+// An empty ID is returned if no manifests exist.
+func Latest(backupDir string) (string, error) {
+	manifestDir := fmt.Sprintf("%s/manifests", backupDir)
+
+	var latestID string
+	var latestTimestamp int64
+	walkFn := func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == manifestDir {
+			return filepath.SkipDir
+		} else if err != nil {
+			return err
+		}
+
+		if info.IsDir() || !strings.HasSuffix(path, ".manifest") {
+			return nil
+		}
+
+		timestamp, err := strconv.ParseInt(strings.TrimSuffix(info.Name(), ".manifest"), 10, 64)
+		if err != nil {
+			// Not written by kopi
+			return nil
+		}
+
+		if latestID == "" || timestamp > latestTimestamp {
+			if latestID, err = filepath.Rel(manifestDir, path); err != nil {
+				return err
+			}
+			latestTimestamp = timestamp
+		}
+		return nil
+	}
+
+	if err := filepath.Walk(manifestDir, walkFn); err != nil {
+		return "", fmt.Errorf("failed to find latest manifest: %s", err.Error())
+	}
+	return filepath.ToSlash(latestID), nil
+}
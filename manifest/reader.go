@@ -3,6 +3,7 @@ package manifest
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 	_ "github.com/mboye/kopi/loglevel"
 	"github.com/mboye/kopi/model"
 	"github.com/mboye/kopi/outputhandler"
+	"github.com/mboye/kopi/repository"
 	"github.com/mboye/kopi/security"
 	"github.com/mboye/kopi/stage"
 	log "github.com/sirupsen/logrus"
@@ -21,11 +23,12 @@ type reader struct {
 	inputDir string
 	decrypt  bool
 	id       string
+	output   outputhandler.OutputHandler
 }
 
-var _ stage.Stage = (*reader)(nil)
+var _ stage.Producer = (*reader)(nil)
 
-func NewReader(inputDir string, decrypt bool, id string) (stage.Stage, error) {
+func NewReader(inputDir string, decrypt bool, id string) (stage.Producer, error) {
 	if inputDir == "" {
 		return nil, errors.New("input dir cannot be empty")
 	}
@@ -34,10 +37,14 @@ func NewReader(inputDir string, decrypt bool, id string) (stage.Stage, error) {
 		return nil, errors.New("cannot read manifest with empty ID")
 	}
 
-	return &reader{inputDir, decrypt, id}, nil
+	return &reader{inputDir, decrypt, id, outputhandler.Stdout}, nil
 }
 
-func (r *reader) Execute() error {
+func (r *reader) SetOutput(output outputhandler.OutputHandler) {
+	r.output = output
+}
+
+func (r *reader) Execute(ctx context.Context) error {
 	manifestPath := fmt.Sprintf("%s/manifests/%s", r.inputDir, r.id)
 	manifestFile, err := os.Open(manifestPath)
 	if err != nil {
@@ -45,7 +52,7 @@ func (r *reader) Execute() error {
 	}
 	defer manifestFile.Close()
 
-	securityContext, err := security.NewContext(r.inputDir, r.decrypt)
+	securityContext, err := repository.NewSecurityContext(r.inputDir, r.decrypt)
 	if err != nil {
 		return fmt.Errorf("failed to create security context: %s", err.Error())
 	}
@@ -55,7 +62,7 @@ func (r *reader) Execute() error {
 		return fmt.Errorf("failed to decompress manifest: %s", err.Error())
 	}
 
-	compressedData, err := securityContext.Decode(encodedData)
+	compressedData, err := securityContext.Decode(encodedData, security.ManifestIdentity(r.id))
 	if err != nil {
 		return fmt.Errorf("failed to decode manifest: %s", err.Error())
 	}
@@ -65,6 +72,8 @@ func (r *reader) Execute() error {
 		return fmt.Errorf("failed to create decompressor: %s", err.Error())
 	}
 	defer decompressor.Close()
+	// Manifests encrypted before the cipher text format was versioned are followed by padding
+	decompressor.Multistream(false)
 
 	decoder := json.NewDecoder(decompressor)
 	decoder.DisallowUnknownFields()
@@ -80,12 +89,18 @@ func (r *reader) Execute() error {
 	}).Info("read manifest header")
 
 	for decoder.More() {
-		file := model.File{}
-		if err := decoder.Decode(&file); err != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		file := &model.File{}
+		if err := decoder.Decode(file); err != nil {
 			return fmt.Errorf("failed to decode file: %s", err.Error())
 		}
 
-		outputhandler.Stdout.Handle(file)
+		if err := r.output.Handle(file); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -3,18 +3,19 @@ package manifest
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
 	"time"
 
 	"github.com/dustin/go-humanize"
+	"github.com/mboye/kopi/atomicfile"
 	"github.com/mboye/kopi/input"
 	_ "github.com/mboye/kopi/loglevel"
 	"github.com/mboye/kopi/model"
+	"github.com/mboye/kopi/repository"
 	"github.com/mboye/kopi/security"
 	"github.com/mboye/kopi/stage"
 	log "github.com/sirupsen/logrus"
@@ -24,19 +25,37 @@ type writer struct {
 	outputDir   string
 	encrypt     bool
 	description string
+	source      input.Source
+	id          string
 }
 
-var _ stage.Stage = (*writer)(nil)
+// Writer is a stage which writes the files on its input to a new manifest.
+type Writer interface {
+	stage.Consumer
+	// ID returns the ID of the written manifest.
+	ID() string
+}
+
+var _ Writer = (*writer)(nil)
 
-func NewWriter(outputDir string, encrypt bool, description string) (stage.Stage, error) {
+func NewWriter(outputDir string, encrypt bool, description string) (Writer, error) {
 
 	if outputDir == "" {
 		return nil, errors.New("output directory cannot be empty")
 	}
 
-	return &writer{outputDir, encrypt, description}, nil
+	return &writer{outputDir: outputDir, encrypt: encrypt, description: description, source: input.Stdin}, nil
 }
-func (w *writer) Execute() error {
+
+func (w *writer) SetInput(source input.Source) {
+	w.source = source
+}
+
+func (w *writer) ID() string {
+	return w.id
+}
+
+func (w *writer) Execute(ctx context.Context) error {
 	now := time.Now().UTC()
 	manifestFilename := fmt.Sprintf("%d/%02d/%02d/%d.manifest",
 		now.Year(), now.Month(), now.Day(),
@@ -47,9 +66,13 @@ func (w *writer) Execute() error {
 		Date:        now,
 		Description: w.description}
 
-	securityContext, err := security.NewContext(w.outputDir, w.encrypt)
+	securityContext, err := repository.NewSecurityContext(w.outputDir, w.encrypt)
 	if err != nil {
-		log.WithField("error", err).Fatal("failed to create security context")
+		return fmt.Errorf("failed to create security context: %s", err.Error())
+	}
+
+	if err := atomicfile.Sweep(w.outputDir); err != nil {
+		return err
 	}
 
 	compressedManifest := bytes.NewBuffer(nil)
@@ -79,7 +102,7 @@ func (w *writer) Execute() error {
 
 	addToManifest(header)
 
-	err = input.ProcessFilesWithProgress(addFileToManifest, 1)
+	err = input.ProcessSourceWithProgress(ctx, w.source, addFileToManifest, 1)
 	if err != nil {
 		log.WithError(err).Error("failed to create compressed manifest")
 		return err
@@ -88,31 +111,19 @@ func (w *writer) Execute() error {
 
 	log.WithField("size", humanize.Bytes(uint64(compressedManifest.Len()))).Info("compressed manifest created")
 
-	encodedManifest, err := securityContext.Encode(compressedManifest.Bytes())
+	encodedManifest, err := securityContext.Encode(compressedManifest.Bytes(), security.ManifestIdentity(manifestFilename))
 	if err != nil {
 		return err
 	}
 	log.WithField("size", len(encodedManifest)).Debug("encoded compressed manifest")
 
 	outputPath := fmt.Sprintf("%s/manifests/%s", w.outputDir, manifestFilename)
-	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
-		log.Error("failed to create manifest directory")
-		return err
-	}
-
-	outputFile, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
-	if err != nil {
-		return err
-	}
-	defer outputFile.Close()
-	log.WithField("path", outputFile.Name()).Debug("opened manifest file")
-
-	bytesWritten, err := io.CopyN(outputFile, bytes.NewBuffer(encodedManifest), int64(len(encodedManifest)))
-	if err != nil {
+	if err := atomicfile.WriteNewFile(w.outputDir, outputPath, encodedManifest, 0644); err != nil {
 		return fmt.Errorf("failed to save manifest: %s", err.Error())
 	}
 
-	log.WithField("bytes_written", bytesWritten).Debug("wrote manifest")
+	log.WithFields(log.Fields{"path": outputPath, "bytes_written": len(encodedManifest)}).Debug("wrote manifest")
+	w.id = header.ID
 
 	log.WithFields(log.Fields{
 		"id":    header.ID,
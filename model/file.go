@@ -3,16 +3,28 @@ package model
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 type File struct {
-	Path         string      `json:"path"`
-	Size         int64       `json:"size"`
-	ModifiedTime time.Time   `json:"modifiedTime"`
-	Mode         os.FileMode `json:"mode"`
-	Modified     bool        `json:"modified,omitempty"`
-	Blocks       []Block     `json:"blocks,omitempty"`
+	Path             string      `json:"path"`
+	PathBytes        []byte      `json:"pathBytes,omitempty"`
+	Size             int64       `json:"size"`
+	ModifiedTime     time.Time   `json:"modifiedTime"`
+	ChangeTime       time.Time   `json:"changeTime"`
+	Inode            uint64      `json:"inode,omitempty"`
+	Mode             os.FileMode `json:"mode"`
+	UID              int         `json:"uid,omitempty"`
+	GID              int         `json:"gid,omitempty"`
+	LinkTarget       string      `json:"linkTarget,omitempty"`
+	Modified         bool        `json:"modified,omitempty"`
+	MetadataModified bool        `json:"metadataModified,omitempty"`
+	MountPoint       bool        `json:"mountPoint,omitempty"`
+	ContentHash      string      `json:"contentHash,omitempty"`
+	Blocks           []Block     `json:"blocks,omitempty"`
+	Holes            []Hole      `json:"holes,omitempty"`
 }
 
 type Block struct {
@@ -21,18 +33,67 @@ type Block struct {
 	Size   int64  `json:"size"`
 }
 
+// Hole is a region of a sparse file which contains no data and reads as zeros.
+type Hole struct {
+	Offset int64 `json:"offset"`
+	Size   int64 `json:"size"`
+}
+
 func NewFile() *File {
 	return &File{Blocks: []Block{}}
 }
 
 func (f *File) Name() string {
-	return filepath.Base(f.Path)
+	return filepath.Base(f.OSPath())
+}
+
+// SetPath sets the path of the file. Paths which are not valid UTF-8 cannot be represented in JSON,
+// so the original bytes are kept in PathBytes and Path holds a readable approximation.
+func (f *File) SetPath(path string) {
+	if utf8.ValidString(path) {
+		f.Path = path
+		f.PathBytes = nil
+		return
+	}
+
+	f.Path = strings.ToValidUTF8(path, "\uFFFD")
+	f.PathBytes = []byte(path)
+}
+
+// OSPath returns the exact path of the file as known to the filesystem.
+func (f *File) OSPath() string {
+	if f.PathBytes != nil {
+		return string(f.PathBytes)
+	}
+	return f.Path
+}
+
+// IsSymlink reports whether the file is a symbolic link. Its target is kept in LinkTarget.
+func (f *File) IsSymlink() bool {
+	return f.Mode&os.ModeSymlink != 0
 }
 
 func (f *File) AddBlock(block Block) {
 	f.Blocks = append(f.Blocks, block)
 }
 
+// ContentChanged reports whether the content hashes of two files differ.
+// Files without a content hash are assumed to be unchanged.
+func ContentChanged(a, b *File) bool {
+	return a.ContentHash != "" && b.ContentHash != "" && a.ContentHash != b.ContentHash
+}
+
+// InodeChanged reports whether a file has been replaced by another file at the same path.
+func InodeChanged(a, b *File) bool {
+	return a.Inode != 0 && b.Inode != 0 && a.Inode != b.Inode
+}
+
+// ChangeTimeChanged reports whether the metadata of a file, e.g. permissions or extended attributes, has changed.
+func ChangeTimeChanged(a, b *File) bool {
+	return !a.ChangeTime.IsZero() && !b.ChangeTime.IsZero() && a.ChangeTime.UTC() != b.ChangeTime.UTC()
+}
+
 func FilesEqual(a, b *File) bool {
-	return a.Path == b.Path && a.Size == b.Size && a.ModifiedTime.UTC() == b.ModifiedTime.UTC() && a.Mode == b.Mode
+	return a.OSPath() == b.OSPath() && a.Size == b.Size && a.ModifiedTime.UTC() == b.ModifiedTime.UTC() && a.Mode == b.Mode &&
+		a.LinkTarget == b.LinkTarget
 }
@@ -0,0 +1,36 @@
+package outputhandler
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mboye/kopi/model"
+)
+
+// ErrAborted is returned when a file cannot be passed on, because the pipeline has been aborted.
+var ErrAborted = errors.New("pipeline aborted")
+
+type channelHandler struct {
+	files chan<- *model.File
+	abort <-chan struct{}
+}
+
+// NewChannelHandler passes files to another stage in the same process. Handling blocks until the file
+// is received or abort is closed.
+func NewChannelHandler(files chan<- *model.File, abort <-chan struct{}) OutputHandler {
+	return &channelHandler{files: files, abort: abort}
+}
+
+func (oh *channelHandler) Handle(obj interface{}) error {
+	file, ok := obj.(*model.File)
+	if !ok {
+		return fmt.Errorf("cannot pass %T to channel", obj)
+	}
+
+	select {
+	case oh.files <- file:
+		return nil
+	case <-oh.abort:
+		return ErrAborted
+	}
+}
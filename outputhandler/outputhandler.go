@@ -5,3 +5,10 @@ type OutputHandler interface {
 }
 
 var Stdout = NewStdoutHandler()
+
+// Func adapts an ordinary function to the OutputHandler interface.
+type Func func(obj interface{}) error
+
+func (f Func) Handle(obj interface{}) error {
+	return f(obj)
+}
@@ -2,7 +2,7 @@ package outputhandler
 
 import (
 	"encoding/json"
-	"log"
+	"fmt"
 	"os"
 )
 
@@ -12,10 +12,9 @@ func NewStdoutHandler() OutputHandler {
 	return &stdoutHandler{encoder: json.NewEncoder(os.Stdout)}
 }
 
-func (oh *stdoutHandler) Handle(obj interface{}) (err error) {
-	err = oh.encoder.Encode(obj)
-	if err != nil {
-		log.Fatalf("failed to encode: %s", err.Error())
+func (oh *stdoutHandler) Handle(obj interface{}) error {
+	if err := oh.encoder.Encode(obj); err != nil {
+		return fmt.Errorf("failed to encode: %s", err.Error())
 	}
-	return
+	return nil
 }
@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mboye/kopi/atomicfile"
+	"github.com/mboye/kopi/security"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ConfigFileName = "config"
+	// Version is the repository format written by Init
+	Version = 1
+
+	ChunkerFixed    = "fixed"
+	CompressionNone = "none"
+)
+
+// Config describes the format of a repository. It is written once by Init.
+type Config struct {
+	Version     int    `json:"version"`
+	Chunker     string `json:"chunker"`
+	Compression string `json:"compression"`
+	security.Config
+}
+
+func DefaultConfig(encrypt bool) Config {
+	return Config{
+		Version:     Version,
+		Chunker:     ChunkerFixed,
+		Compression: CompressionNone,
+		Config:      security.DefaultConfig(encrypt)}
+}
+
+// Init creates a repository in dir. Blocks and manifests stored in dir before repositories were initialized
+// explicitly are adopted: the repository keeps their salt, SHA-1 block IDs and password derived key, so they
+// remain valid.
+func Init(dir string, config Config) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	configPath := filepath.Join(dir, ConfigFileName)
+	if _, err := os.Stat(configPath); err == nil {
+		return fmt.Errorf("repository already initialized: %s", dir)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to check repository config: %s", err.Error())
+	}
+
+	adopt, err := hasLegacyData(dir)
+	if err != nil {
+		return err
+	}
+
+	if adopt {
+		config.Config = config.Config.Adopt()
+		log.WithField("hash", config.Hash).Info("Adopting existing blocks and manifests")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create repository directory: %s", err.Error())
+	}
+
+	if err := security.CreateSalt(dir); err != nil {
+		return err
+	}
+
+	// The key of adopted data is derived from the password, so it is unlocked without a key slot
+	if config.Encrypted() && !adopt {
+		if err := security.CreateMasterKey(dir, config.Config, "initial key"); err != nil {
+			return err
+		}
+	}
+
+	if err := SaveConfig(dir, &config); err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"path":       dir,
+		"version":    config.Version,
+		"encryption": config.Encryption}).Info("Initialized repository")
+	return nil
+}
+
+// hasLegacyData reports whether dir holds a salt and data stored before repositories were initialized explicitly.
+func hasLegacyData(dir string) (bool, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to list repository directory: %s", err.Error())
+	}
+
+	salt, data := false, false
+	for _, entry := range entries {
+		if entry.Name() == security.SaltFileName {
+			salt = true
+		} else {
+			data = true
+		}
+	}
+	return salt && data, nil
+}
+
+// LoadConfig reads the config of the repository in dir. It fails if dir is not an initialized repository.
+func LoadConfig(dir string) (*Config, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, ConfigFileName))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("not an initialized repository: %s (run 'kopi init' to create one)", dir)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read repository config: %s", err.Error())
+	}
+
+	config := &Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to decode repository config: %s", err.Error())
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid repository config: %s", err.Error())
+	}
+	return config, nil
+}
+
+// SaveConfig replaces the config of the repository in dir.
+func SaveConfig(dir string, config *Config) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode repository config: %s", err.Error())
+	}
+
+	if err := atomicfile.WriteFile(dir, filepath.Join(dir, ConfigFileName), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write repository config: %s", err.Error())
+	}
+	return nil
+}
+
+// NewSecurityContext creates a security context for the repository in dir. Encryption is enabled
+// by the repository config; encrypt only states that the caller expects an encrypted repository.
+func NewSecurityContext(dir string, encrypt bool) (*security.Context, error) {
+	config, err := LoadConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if encrypt && !config.Encrypted() {
+		return nil, fmt.Errorf("repository is not encrypted: %s", dir)
+	}
+
+	return security.NewContext(dir, config.Config)
+}
+
+func (c *Config) validate() error {
+	if c.Version < 1 {
+		return errors.New("repository version missing")
+	} else if c.Version > Version {
+		return fmt.Errorf("unsupported repository version %d: upgrade kopi", c.Version)
+	}
+
+	if c.Chunker != ChunkerFixed {
+		return fmt.Errorf("unsupported chunker: %s", c.Chunker)
+	}
+
+	if c.Compression != CompressionNone {
+		return fmt.Errorf("unsupported compression: %s", c.Compression)
+	}
+
+	return c.Config.Validate()
+}
@@ -0,0 +1,288 @@
+package restorer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/mboye/kopi/input"
+	"github.com/mboye/kopi/model"
+	"github.com/mboye/kopi/security"
+	log "github.com/sirupsen/logrus"
+)
+
+// Archive formats which files can be restored into instead of the output dir
+const (
+	ArchiveTar     = "tar"
+	ArchiveTarGzip = "tar.gz"
+	ArchiveTarZstd = "tar.zst"
+	ArchiveZip     = "zip"
+
+	// ArchiveStdout is the output path which writes archives to STDOUT
+	ArchiveStdout = "-"
+)
+
+var ArchiveFormats = []string{ArchiveTar, ArchiveTarGzip, ArchiveTarZstd, ArchiveZip}
+
+// archiveWriter adds restored files to an archive. content writes the data of regular files.
+type archiveWriter interface {
+	add(file *model.File, name string, content func(w io.Writer) error) error
+	close() error
+}
+
+func validateArchiveFormat(format string) error {
+	for _, valid := range ArchiveFormats {
+		if format == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported archive format: %s (valid formats: %s)", format, strings.Join(ArchiveFormats, ", "))
+}
+
+func newArchiveWriter(format string, output io.Writer) (archiveWriter, error) {
+	switch format {
+	case ArchiveTar:
+		return &tarArchive{writer: tar.NewWriter(output)}, nil
+	case ArchiveTarGzip:
+		compressor := gzip.NewWriter(output)
+		return &tarArchive{writer: tar.NewWriter(compressor), compressor: compressor}, nil
+	case ArchiveTarZstd:
+		compressor, err := zstd.NewWriter(output)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create compressor: %s", err.Error())
+		}
+		return &tarArchive{writer: tar.NewWriter(compressor), compressor: compressor}, nil
+	case ArchiveZip:
+		return &zipArchive{writer: zip.NewWriter(output)}, nil
+	default:
+		return nil, validateArchiveFormat(format)
+	}
+}
+
+// restoreToArchive writes the files on the input to an archive at the output path instead of the output dir.
+// An incomplete archive file is removed.
+func (r *restorer) restoreToArchive(ctx context.Context, securityContext *security.Context) (err error) {
+	var output io.Writer = os.Stdout
+	if r.outputDir != ArchiveStdout {
+		outputFile, err := os.OpenFile(r.outputDir, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to create archive: %s", err.Error())
+		}
+		defer func() {
+			if closeErr := outputFile.Close(); err == nil && closeErr != nil {
+				err = fmt.Errorf("failed to close archive: %s", closeErr.Error())
+			}
+			if err != nil {
+				os.Remove(r.outputDir)
+			}
+		}()
+		output = outputFile
+	}
+
+	buffered := bufio.NewWriter(output)
+	archive, err := newArchiveWriter(r.Archive, buffered)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{"destination": r.outputDir, "format": r.Archive}).Info("beginning to restore files into archive")
+
+	addFile := func(file *model.File) error {
+		// Archives hold relative paths
+		name := strings.TrimPrefix(filepath.ToSlash(filepath.Clean(file.OSPath())), "/")
+		if name == "." || name == "" {
+			return nil
+		}
+
+		log.WithFields(log.Fields{"path": file.Path, "name": name}).Debug("adding file to archive")
+		return archive.add(file, name, func(w io.Writer) error {
+			return writeContent(ctx, w, file, r.inputDir, securityContext)
+		})
+	}
+
+	addFiles := func(file *model.File) error {
+		files := r.filter.apply(file)
+		if len(files) == 0 {
+			log.WithField("path", file.Path).Debug("skipping file")
+		}
+
+		for _, file := range files {
+			if err := addFile(file); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := input.ProcessFilesWithProgress(ctx, addFiles, uint(r.ProgressInterval)); err != nil {
+		return err
+	}
+
+	if err := archive.close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %s", err.Error())
+	}
+	return buffered.Flush()
+}
+
+// writeContent writes the data of a file in order. Holes are written as zeros. Blocks are verified before
+// they are written, and a cancelled ctx stops it between blocks.
+func writeContent(ctx context.Context, w io.Writer, file *model.File, inputDir string, securityContext *security.Context) error {
+	if file.Size > 0 && len(file.Blocks) == 0 && len(file.Holes) == 0 {
+		return fmt.Errorf("cannot restore non-empty file without blocks: %s", file.Path)
+	}
+
+	blocks := append([]model.Block{}, file.Blocks...)
+	sort.Slice(blocks, func(a, b int) bool { return blocks[a].Offset < blocks[b].Offset })
+
+	offset := int64(0)
+	for _, block := range blocks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if block.Offset < offset {
+			return fmt.Errorf("overlapping blocks in file: %s", file.Path)
+		}
+
+		if err := writeZeros(w, block.Offset-offset); err != nil {
+			return err
+		}
+
+		data, err := readBlock(inputDir, block, securityContext)
+		if err != nil {
+			return err
+		}
+
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		offset = block.Offset + block.Size
+	}
+
+	return writeZeros(w, file.Size-offset)
+}
+
+func writeZeros(w io.Writer, size int64) error {
+	zeros := make([]byte, holeChunkSize)
+	for size > 0 {
+		n := size
+		if n > holeChunkSize {
+			n = holeChunkSize
+		}
+
+		if _, err := w.Write(zeros[:n]); err != nil {
+			return err
+		}
+		size -= n
+	}
+	return nil
+}
+
+type tarArchive struct {
+	writer *tar.Writer
+	// compressor is nil for uncompressed archives
+	compressor io.WriteCloser
+}
+
+func (a *tarArchive) add(file *model.File, name string, content func(w io.Writer) error) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    tarMode(file.Mode),
+		ModTime: file.ModifiedTime,
+		Uid:     file.UID,
+		Gid:     file.GID}
+
+	switch {
+	case file.Mode.IsDir():
+		header.Typeflag = tar.TypeDir
+		header.Name += "/"
+	case file.IsSymlink():
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = file.LinkTarget
+	default:
+		header.Typeflag = tar.TypeReg
+		header.Size = file.Size
+	}
+
+	if err := a.writer.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write archive header: %s", err.Error())
+	}
+
+	if header.Typeflag != tar.TypeReg {
+		return nil
+	}
+	return content(a.writer)
+}
+
+func (a *tarArchive) close() error {
+	if err := a.writer.Close(); err != nil {
+		return err
+	}
+
+	if a.compressor != nil {
+		return a.compressor.Close()
+	}
+	return nil
+}
+
+// tarMode converts a file mode to the permission and special bits of a tar header.
+func tarMode(mode os.FileMode) int64 {
+	tarMode := int64(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		tarMode |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		tarMode |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		tarMode |= 01000
+	}
+	return tarMode
+}
+
+// zipArchive keeps modes and modification times. Zip archives cannot hold ownership.
+type zipArchive struct {
+	writer *zip.Writer
+}
+
+func (a *zipArchive) add(file *model.File, name string, content func(w io.Writer) error) error {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: file.ModifiedTime}
+	header.SetMode(file.Mode)
+
+	if file.Mode.IsDir() {
+		header.Name += "/"
+		header.Method = zip.Store
+	}
+
+	w, err := a.writer.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("failed to write archive header: %s", err.Error())
+	}
+
+	switch {
+	case file.Mode.IsDir():
+		return nil
+	case file.IsSymlink():
+		// Symbolic links are stored with their target as content, as done by Info-ZIP
+		_, err := io.WriteString(w, file.LinkTarget)
+		return err
+	default:
+		return content(w)
+	}
+}
+
+func (a *zipArchive) close() error {
+	return a.writer.Close()
+}
@@ -0,0 +1,81 @@
+package restorer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/mboye/kopi/manifest"
+	"github.com/mboye/kopi/model"
+	"github.com/mboye/kopi/outputhandler"
+	"github.com/mboye/kopi/repository"
+	"github.com/mboye/kopi/stage"
+	log "github.com/sirupsen/logrus"
+)
+
+// errFileFound stops reading the manifest once the file has been found
+var errFileFound = errors.New("file found")
+
+type cat struct {
+	inputDir   string
+	manifestID string
+	path       string
+	decrypt    bool
+	output     io.Writer
+}
+
+var _ stage.Stage = (*cat)(nil)
+
+// NewCat creates a stage which writes the content of the file at path in a manifest to STDOUT.
+func NewCat(inputDir, manifestID, path string, decrypt bool) (stage.Stage, error) {
+	if path == "" {
+		return nil, errors.New("path cannot be empty")
+	}
+
+	return &cat{inputDir, manifestID, filepath.Clean(path), decrypt, os.Stdout}, nil
+}
+
+func (c *cat) Execute(ctx context.Context) error {
+	reader, err := manifest.NewReader(c.inputDir, c.decrypt, c.manifestID)
+	if err != nil {
+		return err
+	}
+
+	var file *model.File
+	reader.SetOutput(outputhandler.Func(func(obj interface{}) error {
+		if candidate := obj.(*model.File); filepath.Clean(candidate.OSPath()) == c.path {
+			file = candidate
+			return errFileFound
+		}
+		return nil
+	}))
+
+	if err := reader.Execute(ctx); err != nil && err != errFileFound {
+		return err
+	}
+
+	switch {
+	case file == nil:
+		return fmt.Errorf("file not found in manifest: %s", c.path)
+	case file.Mode.IsDir():
+		return fmt.Errorf("cannot print directory: %s", c.path)
+	case file.IsSymlink():
+		return fmt.Errorf("cannot print symbolic link: %s -> %s", c.path, file.LinkTarget)
+	}
+
+	securityContext, err := repository.NewSecurityContext(c.inputDir, c.decrypt)
+	if err != nil {
+		return fmt.Errorf("failed to create security context: %s", err.Error())
+	}
+
+	log.WithFields(log.Fields{"path": file.Path, "size": file.Size}).Debug("printing file")
+	buffered := bufio.NewWriter(c.output)
+	if err := writeContent(ctx, buffered, file, c.inputDir, securityContext); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
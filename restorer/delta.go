@@ -0,0 +1,100 @@
+package restorer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mboye/kopi/model"
+	"github.com/mboye/kopi/security"
+)
+
+// Size of the buffer used to compare and clear holes, which may be much larger than blocks
+const holeChunkSize = 64 * 1024
+
+// openForDelta opens the file at path for a delta restore. Existing regular files are opened for reading
+// and writing without truncating them, so blocks which are unchanged can be kept. They are patched in place,
+// unlike files restored by other policies. Other files are replaced.
+func openForDelta(path string, mode os.FileMode) (*outputFile, bool, error) {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) || (err == nil && !info.Mode().IsRegular()) {
+		file, err := createFile(path, mode)
+		return file, false, err
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, mode)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := file.Chmod(mode); err != nil {
+		file.Close()
+		return nil, false, err
+	}
+	return &outputFile{File: file, path: path}, true, nil
+}
+
+// blockMatches reports whether the data of a file at the offset of block has the hash of block.
+func blockMatches(file io.ReaderAt, block model.Block, securityContext *security.Context) (bool, error) {
+	data := make([]byte, block.Size)
+	if _, err := file.ReadAt(data, block.Offset); err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	hasher, err := securityContext.NewHasher()
+	if err != nil {
+		return false, err
+	}
+	hasher.Write(data)
+
+	return fmt.Sprintf("%x", hasher.Sum(nil)) == block.Hash, nil
+}
+
+// holeIsZero reports whether the region of a file covered by hole reads as zeros.
+func holeIsZero(file io.ReaderAt, hole model.Hole) (bool, error) {
+	data := make([]byte, holeChunkSize)
+	zeros := make([]byte, holeChunkSize)
+
+	holeReader := io.NewSectionReader(file, hole.Offset, hole.Size)
+	for {
+		n, err := holeReader.Read(data)
+		if !bytes.Equal(data[:n], zeros[:n]) {
+			return false, nil
+		} else if err == io.EOF {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+}
+
+// clearHole overwrites the data in the region of a file covered by hole with zeros. Chunks which read as
+// zeros already are not written, so they remain holes in sparse files.
+func clearHole(file *os.File, hole model.Hole) error {
+	data := make([]byte, holeChunkSize)
+	zeros := make([]byte, holeChunkSize)
+
+	for offset := hole.Offset; offset < hole.Offset+hole.Size; offset += holeChunkSize {
+		size := hole.Offset + hole.Size - offset
+		if size > holeChunkSize {
+			size = holeChunkSize
+		}
+
+		n, err := file.ReadAt(data[:size], offset)
+		if err != nil && err != io.EOF {
+			return err
+		} else if bytes.Equal(data[:n], zeros[:n]) {
+			continue
+		}
+
+		if _, err := file.WriteAt(zeros[:size], offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
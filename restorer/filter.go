@@ -0,0 +1,152 @@
+package restorer
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/mboye/kopi/model"
+)
+
+const separator = string(filepath.Separator)
+
+// pathFilter selects the files to restore and rewrites the paths they are restored to.
+type pathFilter struct {
+	include, exclude []string
+	pathPrefix       string
+	stripComponents  int
+	targetPrefix     string
+
+	// skippedDirs holds the directories above the current file which were not selected, outermost first,
+	// until a file below them is selected and they are restored with their own modes.
+	skippedDirs []skippedDir
+}
+
+type skippedDir struct {
+	originalPath string
+	file         *model.File
+}
+
+func newPathFilter(options Options) (*pathFilter, error) {
+	for _, pattern := range append(append([]string{}, options.Include...), options.Exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err.Error())
+		}
+	}
+
+	if options.StripComponents < 0 {
+		return nil, fmt.Errorf("cannot strip %d path components", options.StripComponents)
+	}
+
+	pathPrefix := ""
+	if options.PathPrefix != "" {
+		pathPrefix = filepath.Clean(options.PathPrefix)
+	}
+
+	return &pathFilter{
+		include:         options.Include,
+		exclude:         options.Exclude,
+		pathPrefix:      pathPrefix,
+		stripComponents: options.StripComponents,
+		targetPrefix:    options.TargetPrefix}, nil
+}
+
+// apply returns the files to restore for a file from the index, with rewritten paths. These are the file if
+// it is selected, preceded by the skipped directories above it, which are then only restored once. Skipped
+// directories are dropped once a file outside of them is reached, which is final in walk order. Indexes in
+// another order, e.g. from multiple workers without -sorted, may get parent directories with default modes.
+func (f *pathFilter) apply(file *model.File) []*model.File {
+	originalPath := filepath.Clean(file.OSPath())
+	for len(f.skippedDirs) > 0 && !isParent(f.skippedDirs[len(f.skippedDirs)-1].originalPath, originalPath) {
+		f.skippedDirs = f.skippedDirs[:len(f.skippedDirs)-1]
+	}
+
+	path, selected := f.rewrite(originalPath)
+	if !selected {
+		if file.Mode.IsDir() {
+			f.skippedDirs = append(f.skippedDirs, skippedDir{originalPath, file})
+		}
+		return nil
+	}
+
+	files := []*model.File{}
+	for _, dir := range f.skippedDirs {
+		if dirPath, ok := f.target(dir.originalPath); ok {
+			dir.file.SetPath(dirPath)
+			files = append(files, dir.file)
+		}
+	}
+	f.skippedDirs = nil
+
+	file.SetPath(path)
+	return append(files, file)
+}
+
+// isParent reports whether dir is a parent directory of path.
+func isParent(dir, path string) bool {
+	if dir == "." {
+		return !filepath.IsAbs(path) && path != "."
+	}
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, separator)+separator)
+}
+
+// rewrite returns the path a file is restored to, relative to the output dir, or false if the file is skipped.
+func (f *pathFilter) rewrite(path string) (string, bool) {
+	if !f.selected(path) {
+		return "", false
+	}
+	return f.target(path)
+}
+
+// target returns the path a file is restored to, or false if all of its components are stripped.
+func (f *pathFilter) target(path string) (string, bool) {
+	if f.stripComponents == 0 && f.targetPrefix == "" {
+		return path, true
+	}
+
+	components := []string{}
+	for _, component := range strings.Split(path, separator) {
+		if component != "" && component != "." {
+			components = append(components, component)
+		}
+	}
+
+	if len(components) <= f.stripComponents {
+		return "", false
+	}
+	components = components[f.stripComponents:]
+
+	return filepath.Join(append([]string{f.targetPrefix}, components...)...), true
+}
+
+func (f *pathFilter) selected(path string) bool {
+	path = filepath.Clean(path)
+
+	if f.pathPrefix != "" && path != f.pathPrefix &&
+		!strings.HasPrefix(path, strings.TrimSuffix(f.pathPrefix, separator)+separator) {
+		return false
+	}
+
+	if len(f.include) > 0 && !matchesAny(f.include, path) {
+		return false
+	}
+	return !matchesAny(f.exclude, path)
+}
+
+// matchesAny reports whether a pattern matches the path or one of its parent directories, so a pattern
+// matching a directory selects everything below it. Patterns without a separator match base names.
+func matchesAny(patterns []string, path string) bool {
+	for ; path != "." && path != separator; path = filepath.Dir(path) {
+		for _, pattern := range patterns {
+			name := path
+			if !strings.Contains(pattern, separator) {
+				name = filepath.Base(path)
+			}
+
+			if matched, _ := filepath.Match(pattern, name); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package restorer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mboye/kopi/model"
+	"github.com/mboye/kopi/security"
+	log "github.com/sirupsen/logrus"
+)
+
+// Policies for files which already exist in the output dir
+const (
+	OverwriteNever       = "never"
+	OverwriteAlways      = "always"
+	OverwriteIfNewer     = "if-newer"
+	OverwriteIfDifferent = "if-different"
+)
+
+// restoreTempSuffix ends the names of temporary files which are renamed to restored files
+const restoreTempSuffix = ".kopi-restore"
+
+var OverwritePolicies = []string{OverwriteNever, OverwriteAlways, OverwriteIfNewer, OverwriteIfDifferent}
+
+func validateOverwritePolicy(policy string) error {
+	for _, valid := range OverwritePolicies {
+		if policy == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported overwrite policy: %s (valid policies: %s)", policy, strings.Join(OverwritePolicies, ", "))
+}
+
+// shouldRestore decides whether a file is restored to outputPath, which may exist already.
+func shouldRestore(file *model.File, outputPath string, options Options, securityContext *security.Context) (bool, error) {
+	info, err := os.Lstat(outputPath)
+	if os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	logger := log.WithFields(log.Fields{"path": outputPath, "overwrite": options.Overwrite})
+
+	switch options.Overwrite {
+	case OverwriteAlways:
+		return true, nil
+	case OverwriteIfNewer:
+		if !file.ModifiedTime.After(info.ModTime()) {
+			logger.Debug("skipping file which is not older than the stored file")
+			return false, nil
+		}
+		return true, nil
+	case OverwriteIfDifferent:
+		if !info.Mode().IsRegular() || info.Size() != file.Size || !info.ModTime().Equal(file.ModifiedTime) {
+			return true, nil
+		} else if options.Delta {
+			// A delta restore compares the content itself and only writes the blocks which differ
+			return true, nil
+		}
+
+		differs, err := contentDiffers(file, outputPath, securityContext)
+		if err != nil {
+			logger.WithError(err).Warn("failed to compare existing file")
+			return true, nil
+		} else if !differs {
+			logger.Debug("skipping unchanged file")
+			return false, nil
+		}
+
+		logger.Info("replacing damaged file")
+		return true, nil
+	default:
+		logger.Info("skipping existing file")
+		return false, nil
+	}
+}
+
+// contentDiffers reports whether the content of the file at path differs from the blocks and holes of file.
+func contentDiffers(file *model.File, path string, securityContext *security.Context) (bool, error) {
+	existing, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer existing.Close()
+
+	for _, block := range file.Blocks {
+		if matches, err := blockMatches(existing, block, securityContext); err != nil {
+			return false, err
+		} else if !matches {
+			return true, nil
+		}
+	}
+
+	for _, hole := range file.Holes {
+		if zero, err := holeIsZero(existing, hole); err != nil {
+			return false, err
+		} else if !zero {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// outputFile is a file being restored. Files are not written in place: a temporary file is created in the same
+// directory and renamed to the path of the file by commit, so a restore which fails or is interrupted leaves
+// neither a partial file nor a damaged existing file behind.
+type outputFile struct {
+	*os.File
+	path      string
+	temporary bool
+	committed bool
+}
+
+// createFile creates a temporary file which replaces any file at path once committed.
+func createFile(path string, mode os.FileMode) (*outputFile, error) {
+	if info, err := os.Lstat(path); err == nil && info.IsDir() {
+		// Renaming cannot replace a directory. Only empty directories are removed.
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// Renaming replaces symbolic links themselves, so they are never written through
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*"+restoreTempSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &outputFile{File: file, path: path, temporary: true}, nil
+}
+
+// commit syncs a temporary file and renames it to the path of the restored file.
+func (f *outputFile) commit(mode os.FileMode) error {
+	if !f.temporary {
+		f.committed = true
+		return nil
+	}
+
+	if err := f.Chmod(mode); err != nil {
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(f.Name(), f.path); err != nil {
+		return err
+	}
+	f.committed = true
+	return nil
+}
+
+// Close closes the file. Temporary files which were not committed are removed.
+func (f *outputFile) Close() error {
+	err := f.File.Close()
+	if f.temporary && !f.committed {
+		os.Remove(f.Name())
+	}
+	return err
+}
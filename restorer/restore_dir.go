@@ -9,7 +9,7 @@ import (
 )
 
 func restoreDir(file *model.File, outputDir string, dryRun bool) error {
-	outputPath := fmt.Sprintf("%s/%s", outputDir, file.Path)
+	outputPath := fmt.Sprintf("%s/%s", outputDir, file.OSPath())
 	log.WithFields(log.Fields{
 		"path": outputPath,
 		"mode": file.Mode}).Debug("restoring directory")
@@ -1,6 +1,7 @@
 package restorer
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -12,19 +13,30 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func restoreFile(file *model.File, inputDir, outputDir string, securityContext *security.Context, dryRun bool) error {
+// restoreFile restores the data of a file block by block. A cancelled ctx stops it between blocks, before
+// the file is committed.
+func restoreFile(ctx context.Context, file *model.File, inputDir, outputDir string, securityContext *security.Context,
+	options Options) error {
 	log.WithFields(log.Fields{
 		"path": file.Path,
 		"mode": file.Mode}).Debug("restoring file")
 
-	if file.Size > 0 && (file.Blocks == nil || len(file.Blocks) == 0) {
+	if file.Size > 0 && len(file.Blocks) == 0 && len(file.Holes) == 0 {
 		return errors.New("cannot restore non-empty file without blocks")
 	}
 
-	outputPath := fmt.Sprintf("%s/%s", outputDir, file.Path)
+	outputPath := fmt.Sprintf("%s/%s", outputDir, file.OSPath())
 
-	var outputFile *os.File
-	if !dryRun {
+	if restore, err := shouldRestore(file, outputPath, options, securityContext); err != nil {
+		return err
+	} else if !restore {
+		return nil
+	}
+
+	var outputFile *outputFile
+	// existing is set if the output file has content which a delta restore may keep
+	existing := false
+	if !options.DryRun {
 		parentDir := filepath.Dir(outputPath)
 		if err := os.MkdirAll(parentDir, 0755); err != nil {
 			log.WithError(err).Error("failed to create parent directory of file")
@@ -32,86 +44,85 @@ func restoreFile(file *model.File, inputDir, outputDir string, securityContext *
 		}
 
 		var err error
-		outputFile, err = os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, file.Mode)
+		if options.Delta {
+			outputFile, existing, err = openForDelta(outputPath, file.Mode)
+		} else {
+			outputFile, err = createFile(outputPath, file.Mode)
+		}
 		if err != nil {
 			return err
 		}
 		defer outputFile.Close()
 
-		if file.Size == 0 {
-			return nil
+		if existing || len(file.Holes) > 0 {
+			// Extend the file first, so regions without blocks are left as holes
+			if err := outputFile.Truncate(file.Size); err != nil {
+				log.WithError(err).Error("failed to allocate sparse file")
+				return err
+			}
 		}
 	}
 
 	progress := 0
+	reused := 0
 	for _, block := range file.Blocks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		progress++
 		log.WithFields(
 			log.Fields{
 				"progress":     progress,
 				"max_progress": len(file.Blocks)}).Debug("restoring block")
 
-		restoreBlock := func() error {
-			blockPath := fmt.Sprintf("%s/%s/%s.block", inputDir, block.Hash[:2], block.Hash)
-			blockFile, err := os.Open(blockPath)
-			if err != nil {
-				log.WithError(err).Error("failed to open block file")
-				return err
-			}
-			defer blockFile.Close()
-			log.WithField("path", blockPath).Debug("opened block")
-
-			encodedBlockData, err := ioutil.ReadFile(blockPath)
-			if err != nil {
-				log.WithError(err).Error("failed to read block data")
+		if existing {
+			if matches, err := blockMatches(outputFile, block, securityContext); err != nil {
+				log.WithError(err).Error("failed to read existing block")
 				return err
+			} else if matches {
+				log.WithField("offset", block.Offset).Debug("keeping existing block")
+				reused++
+				continue
 			}
-			log.WithField("size", len(encodedBlockData)).Debug("read block data")
-
-			blockData, err := securityContext.Decode(encodedBlockData)
-			actualBlockSize := len(blockData)
-			log.WithField("size", actualBlockSize).Debug("decoded block data")
-
-			if actualBlockSize < int(block.Size) {
-				log.WithFields(
-					log.Fields{
-						"actual_size":       actualBlockSize,
-						"min_expected_size": block.Size,
-						"block_path":        blockPath}).Error("corrupt block detected")
+		}
 
-				return fmt.Errorf("corrupt block: %s", blockPath)
-			}
+		blockData, err := readBlock(inputDir, block, securityContext)
+		if err != nil {
+			return err
+		}
 
-			hasher, err := securityContext.NewHasher()
-			if err != nil {
+		if !options.DryRun {
+			if _, err = outputFile.WriteAt(blockData, block.Offset); err != nil {
+				log.WithError(err).Error("failed to restore block")
 				return err
 			}
+		}
+	}
 
-			if _, err = hasher.Write(blockData[:block.Size]); err != nil {
+	if existing {
+		for _, hole := range file.Holes {
+			if err := clearHole(outputFile.File, hole); err != nil {
+				log.WithError(err).Error("failed to clear hole")
 				return err
 			}
-			hash := fmt.Sprintf("%x", hasher.Sum(nil))
-
-			if hash != block.Hash {
-				log.WithFields(
-					log.Fields{
-						"actual_hash":   hash,
-						"expected_hash": block.Hash}).Error("corrupt block detected")
-
-				return fmt.Errorf("corrupt block: %s", blockPath)
-			}
+		}
 
-			if !dryRun {
-				if _, err = outputFile.Write(blockData[:block.Size]); err != nil {
-					log.WithError(err).Error("failed to restore block")
-					return err
-				}
-			}
+		log.WithFields(log.Fields{
+			"path":           outputPath,
+			"reused_blocks":  reused,
+			"fetched_blocks": len(file.Blocks) - reused}).Debug("patched existing file")
+	}
 
-			return nil
+	if !options.DryRun {
+		if err := outputFile.commit(file.Mode); err != nil {
+			log.WithError(err).Error("failed to replace existing file")
+			return err
 		}
 
-		if err := restoreBlock(); err != nil {
+		// Overwrite policies compare modification times, so they must match the stored file
+		if err := os.Chtimes(outputPath, file.ModifiedTime, file.ModifiedTime); err != nil {
+			log.WithError(err).Error("failed to set modification time")
 			return err
 		}
 	}
@@ -123,3 +134,53 @@ func restoreFile(file *model.File, inputDir, outputDir string, securityContext *
 
 	return nil
 }
+
+// readBlock reads a block from the repository and verifies its hash.
+func readBlock(inputDir string, block model.Block, securityContext *security.Context) ([]byte, error) {
+	blockPath := fmt.Sprintf("%s/%s/%s.block", inputDir, block.Hash[:2], block.Hash)
+	encodedBlockData, err := ioutil.ReadFile(blockPath)
+	if err != nil {
+		log.WithError(err).Error("failed to read block data")
+		return nil, err
+	}
+	log.WithField("size", len(encodedBlockData)).Debug("read block data")
+
+	blockData, err := securityContext.Decode(encodedBlockData, security.BlockIdentity(block.Hash))
+	if err != nil {
+		log.WithError(err).WithField("block_path", blockPath).Error("failed to decode block")
+		return nil, err
+	}
+	actualBlockSize := len(blockData)
+	log.WithField("size", actualBlockSize).Debug("decoded block data")
+
+	if actualBlockSize < int(block.Size) {
+		log.WithFields(
+			log.Fields{
+				"actual_size":       actualBlockSize,
+				"min_expected_size": block.Size,
+				"block_path":        blockPath}).Error("corrupt block detected")
+
+		return nil, fmt.Errorf("corrupt block: %s", blockPath)
+	}
+
+	hasher, err := securityContext.NewHasher()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = hasher.Write(blockData[:block.Size]); err != nil {
+		return nil, err
+	}
+	hash := fmt.Sprintf("%x", hasher.Sum(nil))
+
+	if hash != block.Hash {
+		log.WithFields(
+			log.Fields{
+				"actual_hash":   hash,
+				"expected_hash": block.Hash}).Error("corrupt block detected")
+
+		return nil, fmt.Errorf("corrupt block: %s", blockPath)
+	}
+
+	return blockData[:block.Size], nil
+}
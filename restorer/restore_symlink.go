@@ -0,0 +1,43 @@
+package restorer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mboye/kopi/model"
+	log "github.com/sirupsen/logrus"
+)
+
+func restoreSymlink(file *model.File, outputDir string, options Options) error {
+	outputPath := fmt.Sprintf("%s/%s", outputDir, file.OSPath())
+	logger := log.WithFields(log.Fields{"path": outputPath, "target": file.LinkTarget})
+	logger.Debug("restoring symbolic link")
+
+	if options.DryRun {
+		return nil
+	}
+
+	if info, err := os.Lstat(outputPath); err == nil {
+		if target, err := os.Readlink(outputPath); err == nil && target == file.LinkTarget {
+			return nil
+		} else if options.Overwrite == OverwriteNever {
+			logger.Debug("skipping existing file")
+			return nil
+		} else if info.IsDir() {
+			return fmt.Errorf("cannot replace directory with symbolic link: %s", outputPath)
+		}
+
+		if err := os.Remove(outputPath); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		log.WithError(err).Error("failed to create parent directory of symbolic link")
+		return err
+	}
+	return os.Symlink(file.LinkTarget, outputPath)
+}
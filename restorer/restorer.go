@@ -1,8 +1,15 @@
 package restorer
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
 	"github.com/mboye/kopi/input"
-	"github.com/mboye/kopi/security"
+	"github.com/mboye/kopi/repository"
 	"github.com/mboye/kopi/stage"
 
 	_ "github.com/mboye/kopi/loglevel"
@@ -10,34 +17,140 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// Options controls which files are restored and where.
+type Options struct {
+	DryRun           bool
+	Decrypt          bool
+	ProgressInterval int
+
+	// Include restores only files matching one of these glob patterns, if any are given.
+	// A pattern matching a directory selects everything below it.
+	Include []string
+	// Exclude skips files matching one of these glob patterns. It takes precedence over Include.
+	Exclude []string
+	// PathPrefix restores only the file or directory at this path and the files below it.
+	PathPrefix string
+
+	// StripComponents removes this many leading components from restored paths, like tar.
+	StripComponents int
+	// TargetPrefix is prepended to restored paths, below the output dir.
+	TargetPrefix string
+
+	// Overwrite is the policy for files which exist in the output dir already.
+	Overwrite string
+	// Delta keeps the blocks of existing files which match the stored blocks and only fetches the others.
+	Delta bool
+
+	// Archive is the format of an archive which files are written into instead of the output dir.
+	// The output dir is then the path of the archive, or ArchiveStdout.
+	Archive string
+}
+
 type restorer struct {
 	inputDir, outputDir string
-	dryRun              bool
-	decrypt             bool
-	progressInterval    int
+	Options
+	filter *pathFilter
+	// safeDir is the last parent directory which was found to contain no symbolic links
+	safeDir string
 }
 
 var _ stage.Stage = (*restorer)(nil)
 
-func New(inputDir, outputDir string, dryRun, decrypt bool, progressInterval int) (stage.Stage, error) {
-	return &restorer{inputDir, outputDir, dryRun, decrypt, progressInterval}, nil
+func New(inputDir, outputDir string, options Options) (stage.Stage, error) {
+	if err := validateOverwritePolicy(options.Overwrite); err != nil {
+		return nil, err
+	} else if options.Delta && options.Overwrite == OverwriteNever {
+		return nil, errors.New("delta restore requires an overwrite policy other than never")
+	}
+
+	if options.Archive != "" {
+		if err := validateArchiveFormat(options.Archive); err != nil {
+			return nil, err
+		} else if options.DryRun || options.Delta {
+			return nil, errors.New("cannot combine archive output with dry run or delta restore")
+		}
+	}
+
+	filter, err := newPathFilter(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &restorer{inputDir: inputDir, outputDir: outputDir, Options: options, filter: filter}, nil
 }
 
-func (r *restorer) Execute() error {
-	securityContext, err := security.NewContext(r.inputDir, r.decrypt)
+func (r *restorer) Execute(ctx context.Context) error {
+	securityContext, err := repository.NewSecurityContext(r.inputDir, r.Decrypt)
 	if err != nil {
-		log.WithField("error", err).Fatal("failed to create security context")
+		return fmt.Errorf("failed to create security context: %s", err.Error())
+	}
+
+	if r.Archive != "" {
+		return r.restoreToArchive(ctx, securityContext)
 	}
 
 	log.WithField("destination", r.outputDir).Info("beginning to restore files")
 
-	restoreFile := func(file *model.File) (err error) {
+	restore := func(file *model.File) error {
+		if below, err := r.belowSymlink(file.OSPath()); err != nil {
+			return err
+		} else if below {
+			log.WithField("path", file.Path).Warn("skipping file below symbolic link")
+			return nil
+		}
+
 		if file.Mode.IsDir() {
-			return restoreDir(file, r.outputDir, r.dryRun)
+			return restoreDir(file, r.outputDir, r.DryRun)
+		} else if file.IsSymlink() {
+			return restoreSymlink(file, r.outputDir, r.Options)
 		} else {
-			return restoreFile(file, r.inputDir, r.outputDir, securityContext, r.dryRun)
+			return restoreFile(ctx, file, r.inputDir, r.outputDir, securityContext, r.Options)
+		}
+	}
+
+	restoreFiles := func(file *model.File) error {
+		files := r.filter.apply(file)
+		if len(files) == 0 {
+			log.WithField("path", file.Path).Debug("skipping file")
+		}
+
+		for _, file := range files {
+			if err := restore(file); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return input.ProcessFilesWithProgress(ctx, restoreFiles, uint(r.ProgressInterval))
+}
+
+// belowSymlink reports whether a parent directory of path in the output dir is a symbolic link. Files are not
+// restored through symbolic links, which may have been restored from the same manifest and point anywhere.
+func (r *restorer) belowSymlink(path string) (bool, error) {
+	dir := filepath.Dir(filepath.Clean(path))
+	if dir == r.safeDir {
+		return false, nil
+	}
+
+	parent := r.outputDir
+	for _, component := range strings.Split(dir, separator) {
+		if component == "" || component == "." {
+			continue
+		}
+
+		parent = filepath.Join(parent, component)
+		info, err := os.Lstat(parent)
+		if os.IsNotExist(err) {
+			// Missing directories are created as directories
+			return false, nil
+		} else if err != nil {
+			return false, err
+		} else if info.Mode()&os.ModeSymlink != 0 {
+			return true, nil
 		}
 	}
 
-	return input.ProcessFilesWithProgress(restoreFile, uint(r.progressInterval))
+	r.safeDir = dir
+	return false, nil
 }
@@ -0,0 +1,13 @@
+//go:build darwin || freebsd || netbsd
+// +build darwin freebsd netbsd
+
+package scanner
+
+import (
+	"syscall"
+	"time"
+)
+
+func changeTime(stat *syscall.Stat_t) time.Time {
+	return time.Unix(stat.Ctimespec.Unix())
+}
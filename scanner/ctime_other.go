@@ -0,0 +1,14 @@
+//go:build !linux && !openbsd && !dragonfly && !solaris && !darwin && !freebsd && !netbsd && !windows && !plan9
+// +build !linux,!openbsd,!dragonfly,!solaris,!darwin,!freebsd,!netbsd,!windows,!plan9
+
+package scanner
+
+import (
+	"syscall"
+	"time"
+)
+
+// changeTime is not known on this platform, so change time detection is disabled.
+func changeTime(stat *syscall.Stat_t) time.Time {
+	return time.Time{}
+}
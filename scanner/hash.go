@@ -0,0 +1,23 @@
+package scanner
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+)
+
+// hashContent returns the SHA-256 hash of the content of a file.
+func hashContent(path string) (string, error) {
+	inputFile, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer inputFile.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, inputFile); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
@@ -0,0 +1,47 @@
+package scanner
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+const mountInfoPath = "/proc/self/mountinfo"
+
+// mountPoints returns the mount points of the process. Bind mounts of a file system onto itself
+// have the device ID of their parent, so they are only found this way.
+func mountPoints() (map[string]bool, error) {
+	data, err := ioutil.ReadFile(mountInfoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	points := map[string]bool{}
+	for _, line := range strings.Split(string(data), "\n") {
+		// The mount point is the fifth field
+		if fields := strings.Fields(line); len(fields) >= 5 {
+			points[unescapeMountPath(fields[4])] = true
+		}
+	}
+	return points, nil
+}
+
+// unescapeMountPath decodes the octal escapes of whitespace and backslashes in mountinfo paths.
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+
+	var unescaped strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+4 <= len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				unescaped.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		unescaped.WriteByte(path[i])
+	}
+	return unescaped.String()
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package scanner
+
+// mountPoints is not supported on this platform, so mount points are only detected by their device ID.
+func mountPoints() (map[string]bool, error) {
+	return nil, nil
+}
@@ -1,13 +1,14 @@
 package scanner
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math/rand"
 	"os"
-	"os/signal"
 	"path/filepath"
-	"syscall"
-	"unicode/utf8"
+	"sort"
+	"time"
 
 	"github.com/dustin/go-humanize"
 	"github.com/mboye/kopi/model"
@@ -16,33 +17,109 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// Options controls how the scanner walks the file system.
+type Options struct {
+	Recursive    bool
+	Initial      bool
+	WithProgress bool
+
+	// OneFileSystem stops the scanner from crossing into other file systems, e.g. /proc or NFS mounts.
+	// Mount points are detected by device ID, and on Linux also by the mount table, which finds bind mounts.
+	OneFileSystem bool
+	// ListMountPoints adds the mount points skipped by OneFileSystem to the index as empty directories.
+	ListMountPoints bool
+
+	// Workers is the number of directories read in parallel. A single worker walks the tree in order.
+	Workers int
+	// Sorted emits files in the same order as a single worker would, at the cost of buffering the index.
+	Sorted bool
+
+	// ContentHash records a hash of the content of regular files, so changes can be detected
+	// even if size and modification time are preserved.
+	ContentHash bool
+	// ContentHashSample is the percentage of files to hash per run.
+	ContentHashSample int
+}
+
 type scanner struct {
-	rootPath     string
-	recursive    bool
-	initial      bool
-	withProgress bool
+	rootPath string
+	Options
+	output oh.OutputHandler
 }
 
-var _ stage.Stage = (*scanner)(nil)
+var _ stage.Producer = (*scanner)(nil)
 
-func New(rootPath string, recursive bool, initial bool, withProgress bool) (stage.Stage, error) {
+func New(rootPath string, options Options) (stage.Producer, error) {
 	if rootPath == "" {
 		return nil, errors.New("cannot scan empty path")
 	}
 
-	return &scanner{
-		rootPath:  rootPath,
-		recursive: recursive,
-		initial:   initial, withProgress: withProgress}, nil
+	if options.ListMountPoints && !options.OneFileSystem {
+		return nil, errors.New("listing mount points requires one file system mode")
+	}
+
+	if options.Workers < 1 {
+		return nil, errors.New("number of workers must be >= 1")
+	}
+
+	if options.ContentHash && (options.ContentHashSample < 1 || options.ContentHashSample > 100) {
+		return nil, errors.New("content hash sample must be between 1 and 100 percent")
+	}
+
+	return &scanner{rootPath: rootPath, Options: options, output: oh.Stdout}, nil
 }
 
-func (s *scanner) Execute() error {
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
-	defer signal.Reset(syscall.SIGINT, syscall.SIGTERM)
+func (s *scanner) SetOutput(output oh.OutputHandler) {
+	s.output = output
+}
 
+func (s *scanner) Execute(ctx context.Context) error {
 	log.Infof("Indexing path: %s", s.rootPath)
-	log.Infof("Recursive: %t", s.recursive)
+	log.Infof("Recursive: %t", s.Recursive)
+
+	// Files to hash are sampled at random. walkFn is only called from this goroutine.
+	sample := rand.New(rand.NewSource(time.Now().UnixNano()))
+	if s.ContentHash {
+		log.Infof("Hashing content of %d%% of files", s.ContentHashSample)
+	}
+
+	var rootDevice uint64
+	var mounts map[string]bool
+	var realRoot string
+	if s.OneFileSystem {
+		rootInfo, err := os.Lstat(s.rootPath)
+		if err != nil {
+			log.Errorf("Failed to walk path: %s", s.rootPath)
+			return fmt.Errorf("indexing failed: %s", err.Error())
+		}
+
+		var ok bool
+		if rootDevice, ok = deviceID(rootInfo); !ok {
+			return errors.New("one file system mode is not supported on this platform")
+		}
+		log.WithField("device", rootDevice).Info("Staying on one file system")
+
+		if mounts, err = mountPoints(); err != nil {
+			log.WithError(err).Warn("Failed to list mount points. Bind mounts may be crossed.")
+		}
+
+		if realRoot, err = filepath.Abs(s.rootPath); err == nil {
+			realRoot, err = filepath.EvalSymlinks(realRoot)
+		}
+		if err != nil {
+			return fmt.Errorf("indexing failed: %s", err.Error())
+		}
+	}
+
+	// isMountPoint reports whether a path below the root is in the mount table
+	isMountPoint := func(path string) bool {
+		if len(mounts) == 0 || path == s.rootPath {
+			return false
+		}
+
+		relativePath, err := filepath.Rel(s.rootPath, path)
+		return err == nil && mounts[filepath.Join(realRoot, relativePath)]
+	}
 
 	fileCount := int64(0)
 	byteCount := int64(0)
@@ -55,68 +132,142 @@ func (s *scanner) Execute() error {
 		}
 	}
 
-	walkFn := func(path string, info os.FileInfo, err error) error {
-		if !utf8.ValidString(path) {
-			log.WithField("path", path).Warn("ignoring file with non-utf8 path")
+	var bufferedFiles []*model.File
+	emit := func(file *model.File) error {
+		if s.Sorted && s.Workers > 1 {
+			bufferedFiles = append(bufferedFiles, file)
 			return nil
 		}
+		return s.output.Handle(file)
+	}
 
+	walkFn := func(path string, info os.FileInfo, err error) error {
 		if os.IsPermission(err) {
 			log.WithField("path", path).Warn("Permission denied")
+			if info == nil {
+				return nil
+			}
 		} else if err != nil {
 			log.Errorf("Failed to walk path: %s", path)
 			return err
 		}
 
-		select {
-		case sig := <-signals:
-			log.Fatalf("Received signal: %s", sig.String())
-		default:
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
 		log.Debugf("Walking path: %s", path)
 
-		if info.IsDir() && path != s.rootPath && !s.recursive {
+		if info.IsDir() && path != s.rootPath && !s.Recursive {
 			return filepath.SkipDir
 		}
 
-		if !info.IsDir() && !info.Mode().IsRegular() {
+		if s.OneFileSystem {
+			if device, _ := deviceID(info); device != rootDevice || isMountPoint(path) {
+				if !info.IsDir() {
+					log.WithField("path", path).Debug("Ignoring file on other file system")
+					return nil
+				}
+
+				log.WithFields(log.Fields{"path": path, "device": device}).Info("Skipping mount point")
+				if s.ListMountPoints {
+					mountPoint := &model.File{
+						Mode:         info.Mode(),
+						ModifiedTime: info.ModTime().UTC(),
+						MountPoint:   true}
+					mountPoint.SetPath(path)
+
+					if err := emit(mountPoint); err != nil {
+						return fmt.Errorf("output handler failed: %s", err.Error())
+					}
+				}
+				return filepath.SkipDir
+			}
+		}
+
+		symlink := info.Mode()&os.ModeSymlink != 0
+		if !info.IsDir() && !info.Mode().IsRegular() && !symlink {
 			log.WithField("path", path).Debug("Ignoring non-regular file")
 			return nil
 		}
 
 		size := int64(0)
-		if !info.IsDir() {
+		if info.Mode().IsRegular() {
 			size = info.Size()
 		}
 
 		file := &model.File{
-			Path:         path,
 			Size:         size,
 			Mode:         info.Mode(),
 			ModifiedTime: info.ModTime().UTC()}
+		file.SetPath(path)
+
+		if symlink {
+			if file.LinkTarget, err = os.Readlink(path); err != nil {
+				log.WithError(err).WithField("path", path).Warn("Failed to read symbolic link")
+				return nil
+			}
+		}
 
-		if s.initial {
+		if uid, gid, ok := owner(info); ok {
+			file.UID = uid
+			file.GID = gid
+		}
+
+		if inode, changeTime, ok := inodeAndChangeTime(info); ok {
+			file.Inode = inode
+			file.ChangeTime = changeTime.UTC()
+		}
+
+		if s.Initial {
 			file.Modified = true
 		}
 
-		if err := oh.Stdout.Handle(file); err != nil {
+		if s.ContentHash && info.Mode().IsRegular() && sample.Intn(100) < s.ContentHashSample {
+			if file.ContentHash, err = hashContent(path); err != nil {
+				log.WithError(err).WithField("path", path).Warn("Failed to hash file content")
+			}
+		}
+
+		if err := emit(file); err != nil {
 			return fmt.Errorf("output handler failed: %s", err.Error())
 		}
 		fileCount++
 		byteCount += size
 
-		if s.withProgress {
+		if s.WithProgress {
 			printProgress()
 		}
 
 		return nil
 	}
 
-	if err := filepath.Walk(s.rootPath, walkFn); err != nil {
+	var err error
+	if s.Workers > 1 {
+		log.Infof("Workers: %d", s.Workers)
+		err = walkConcurrently(s.rootPath, s.Workers, walkFn)
+	} else {
+		err = filepath.Walk(s.rootPath, walkFn)
+	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	} else if err != nil {
 		return fmt.Errorf("indexing failed: %s", err.Error())
 	}
 
+	if bufferedFiles != nil {
+		sort.Slice(bufferedFiles, func(a, b int) bool {
+			return walkOrderLess(bufferedFiles[a].OSPath(), bufferedFiles[b].OSPath())
+		})
+
+		for _, file := range bufferedFiles {
+			if err := s.output.Handle(file); err != nil {
+				return fmt.Errorf("output handler failed: %s", err.Error())
+			}
+		}
+	}
+
 	log.Infof("Number of files indexed: %d", fileCount)
 	log.Infof("Number of bytes indexed: %s", humanize.Bytes(uint64(byteCount)))
 	return nil
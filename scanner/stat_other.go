@@ -0,0 +1,21 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package scanner
+
+import (
+	"os"
+	"time"
+)
+
+func deviceID(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+func owner(info os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
+
+func inodeAndChangeTime(info os.FileInfo) (uint64, time.Time, bool) {
+	return 0, time.Time{}, false
+}
@@ -0,0 +1,37 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package scanner
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// deviceID returns the ID of the device containing the file.
+func deviceID(info os.FileInfo) (uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(stat.Dev), true
+}
+
+// owner returns the user and group ID of the file.
+func owner(info os.FileInfo) (int, int, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
+
+// inodeAndChangeTime returns the inode number and status change time of the file.
+func inodeAndChangeTime(info os.FileInfo) (uint64, time.Time, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, time.Time{}, false
+	}
+	return uint64(stat.Ino), changeTime(stat), true
+}
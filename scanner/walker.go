@@ -0,0 +1,139 @@
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type dirEntry struct {
+	path string
+	info os.FileInfo
+	err  error
+}
+
+type dirListing struct {
+	path    string
+	entries []dirEntry
+	err     error
+}
+
+// walkConcurrently walks the file tree rooted at root like filepath.Walk, but reads directories and
+// calls lstat on their entries using a bounded pool of workers. walkFn is only called from the calling
+// goroutine. Entries within a directory are visited in lexical order, but directories may be visited
+// in any order.
+func walkConcurrently(root string, workers int, walkFn filepath.WalkFunc) error {
+	rootInfo, err := os.Lstat(root)
+	if err = walkFn(root, rootInfo, err); err != nil {
+		if err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+
+	if rootInfo == nil || !rootInfo.IsDir() {
+		return nil
+	}
+
+	dirs := make(chan string)
+	listings := make(chan dirListing)
+	done := make(chan struct{})
+	defer close(done)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case dir := <-dirs:
+					select {
+					case listings <- listDir(dir):
+					case <-done:
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	pending := []string{root}
+	inFlight := 0
+	for len(pending) > 0 || inFlight > 0 {
+		var nextDirs chan string
+		var nextDir string
+		if len(pending) > 0 {
+			nextDirs = dirs
+			nextDir = pending[len(pending)-1]
+		}
+
+		select {
+		case nextDirs <- nextDir:
+			pending = pending[:len(pending)-1]
+			inFlight++
+		case listing := <-listings:
+			inFlight--
+			if listing.err != nil {
+				if err := walkFn(listing.path, nil, listing.err); err != nil && err != filepath.SkipDir {
+					return err
+				}
+				continue
+			}
+
+			for _, entry := range listing.entries {
+				err := walkFn(entry.path, entry.info, entry.err)
+				if err == filepath.SkipDir {
+					if entry.info == nil || entry.info.IsDir() {
+						continue
+					}
+					// Like filepath.Walk, skipping a file skips the remaining entries of its directory
+					break
+				} else if err != nil {
+					return err
+				}
+
+				if entry.info != nil && entry.info.IsDir() {
+					pending = append(pending, entry.path)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func listDir(dir string) dirListing {
+	listing := dirListing{path: dir}
+
+	dirFile, err := os.Open(dir)
+	if err != nil {
+		listing.err = err
+		return listing
+	}
+	names, err := dirFile.Readdirnames(-1)
+	dirFile.Close()
+	if err != nil {
+		listing.err = err
+		return listing
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			// File was removed while walking
+			continue
+		}
+		listing.entries = append(listing.entries, dirEntry{path: path, info: info, err: err})
+	}
+	return listing
+}
+
+// walkOrderLess orders paths the way filepath.Walk visits them, i.e. by comparing path elements
+// rather than whole strings, so that "a/b" sorts before "a.txt".
+func walkOrderLess(a, b string) bool {
+	separator := string(filepath.Separator)
+	return strings.Replace(a, separator, "\x00", -1) < strings.Replace(b, separator, "\x00", -1)
+}
@@ -0,0 +1,154 @@
+package security
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ObjectBlock      = "block"
+	ObjectManifest   = "manifest"
+	ObjectCheckpoint = "checkpoint"
+
+	// Versioned cipher text starts with a header, which is authenticated along with the object identity.
+	// Cipher text without header is from before the format was versioned and has no additional data.
+	// It is only found in repositories which adopted data stored before they were initialized.
+	// Version 1 is padded to the cipher block size. Version 2 stores the length of the clear text
+	// in front of it, followed by the padding of the repository.
+	cipherFormatVersion1 = 1
+	cipherFormatVersion2 = 2
+
+	lengthSize = 8 // Bytes
+)
+
+var errUnknownCipherFormat = errors.New("unknown cipher text format")
+
+var cipherMagic = []byte("KP")
+
+// Identity names the object a ciphertext belongs to. It is authenticated as additional data,
+// so ciphertext cannot be moved to another object.
+type Identity struct {
+	Type string
+	ID   string
+}
+
+func BlockIdentity(hash string) Identity {
+	return Identity{ObjectBlock, hash}
+}
+
+// ManifestIdentity names a manifest by its path relative to the manifests directory.
+func ManifestIdentity(id string) Identity {
+	return Identity{ObjectManifest, path.Clean(filepath.ToSlash(id))}
+}
+
+// Encrypted reports whether Encode encrypts its input.
+func (ctx *Context) Encrypted() bool {
+	return ctx.cipherBlock != nil
+}
+
+func (ctx *Context) Encode(input []byte, identity Identity) ([]byte, error) {
+	if ctx.cipherBlock == nil {
+		return input, nil
+	}
+
+	aead, err := cipher.NewGCM(ctx.cipherBlock)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init cipher: %s", err.Error())
+	}
+
+	inputSize := len(input)
+	paddedSize := ctx.paddedSize(inputSize)
+	log.WithFields(log.Fields{
+		"clear_text":  inputSize,
+		"padded_size": paddedSize}).Debug("encrypting")
+
+	clearText := make([]byte, lengthSize+paddedSize)
+	binary.BigEndian.PutUint64(clearText, uint64(inputSize))
+	copy(clearText[lengthSize:], input)
+
+	header := cipherHeader(cipherFormatVersion2)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to read nonce: %s", err.Error())
+	}
+
+	output := append(header, nonce...)
+	return aead.Seal(output, nonce, clearText, additionalData(header, identity)), nil
+}
+
+// Decode decrypts cipher text of all format versions. Cipher text without version is only accepted in
+// legacy repositories. Cipher text of version 1 and without version is returned with its padding.
+func (ctx *Context) Decode(input []byte, identity Identity) ([]byte, error) {
+	if ctx.cipherBlock == nil {
+		return input, nil
+	}
+
+	aead, err := cipher.NewGCM(ctx.cipherBlock)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init cipher: %s", err.Error())
+	}
+
+	err = errUnknownCipherFormat
+	for _, version := range []byte{cipherFormatVersion2, cipherFormatVersion1} {
+		header := cipherHeader(version)
+		if !bytes.HasPrefix(input, header) {
+			continue
+		}
+
+		output, openErr := open(aead, input[len(header):], additionalData(header, identity))
+		if openErr == nil && version == cipherFormatVersion2 {
+			output, openErr = unpad(output)
+		}
+		if openErr == nil {
+			return output, nil
+		}
+		err = fmt.Errorf("%s %s: %s", identity.Type, identity.ID, openErr.Error())
+		break
+	}
+
+	// Unversioned cipher text is padded to the cipher block size. It may start with a header by chance.
+	if ctx.legacy && (len(input)-aead.NonceSize())%ctx.cipherBlock.BlockSize() == 0 {
+		output, legacyErr := open(aead, input, nil)
+		if legacyErr == nil {
+			return output, nil
+		} else if err == errUnknownCipherFormat {
+			return nil, legacyErr
+		}
+	}
+	return nil, err
+}
+
+func open(aead cipher.AEAD, input, additionalData []byte) ([]byte, error) {
+	nonceSize := aead.NonceSize()
+	if len(input) < nonceSize {
+		return nil, fmt.Errorf("cipher text too short")
+	}
+
+	log.WithField("size", len(input)-nonceSize).Debug("decrypting block data")
+	output, err := aead.Open(nil, input[:nonceSize], input[nonceSize:], additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %s", err.Error())
+	}
+	return output, nil
+}
+
+func cipherHeader(version byte) []byte {
+	return append(append([]byte{}, cipherMagic...), version)
+}
+
+// additionalData binds the header and object identity to a ciphertext.
+func additionalData(header []byte, identity Identity) []byte {
+	data := append([]byte{}, header...)
+	data = append(data, identity.Type...)
+	data = append(data, 0)
+	return append(data, identity.ID...)
+}
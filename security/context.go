@@ -4,183 +4,180 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
-	"crypto/sha1"
+	"errors"
 	"fmt"
-	"hash"
 	"io"
-	"io/ioutil"
 	"os"
+	"path/filepath"
 
+	"github.com/mboye/kopi/atomicfile"
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/crypto/pbkdf2"
 )
 
 const (
-	keyIterations  = 100000
-	passwordEnvVar = "KOPI_PASSWORD"
-	keyLength      = 32  // Bytes for AES-256,
-	SaltLength     = 128 // Bytes
-	SaltFileName   = "salt"
+	keyLength    = 32  // Bytes for AES-256,
+	SaltLength   = 128 // Bytes
+	SaltFileName = "salt"
+
+	legacyKeyIterations = 100000
+
+	EncryptionNone      = "none"
+	EncryptionAES256GCM = "aes-256-gcm"
 )
 
-type Context struct {
-	Salt        []byte
-	newHash     func() hash.Hash
-	cipherBlock cipher.Block
+// Config selects the algorithms used to hash and encrypt data.
+type Config struct {
+	Hash       string     `json:"hash"`
+	Encryption string     `json:"encryption"`
+	Padding    string     `json:"padding,omitempty"`
+	KDF        *KDFParams `json:"kdf,omitempty"`
+	// Legacy marks repositories which adopted data stored before repositories were initialized explicitly
+	Legacy bool `json:"legacy,omitempty"`
 }
 
-func NewContext(outputDir string, withCrypto bool) (*Context, error) {
-	ctx := &Context{
-		newHash: sha1.New}
-
-	if err := ctx.loadSalt(outputDir); err != nil {
-		return nil, err
+func DefaultConfig(encrypt bool) Config {
+	if !encrypt {
+		return Config{Hash: HashHMACSHA256, Encryption: EncryptionNone}
 	}
 
-	if withCrypto {
-		if err := ctx.initCrypto(); err != nil {
-			return nil, err
-		}
-		log.Info("encryption enabled")
-	} else {
-		log.Info("encryption disabled")
-	}
+	return Config{
+		Hash:       HashHMACSHA256,
+		Encryption: EncryptionAES256GCM,
+		Padding:    PaddingPadme,
+		KDF:        DefaultKDFParams(KDFArgon2id)}
+}
 
-	return ctx, nil
+// Adopt returns the config of a repository adopting data stored before repositories were initialized
+// explicitly. That data has SHA-1 block IDs and is encrypted using the key derived from the password and
+// salt by PBKDF2, which is used as master key while the repository has no key slots. Key slots use the
+// key derivation of the config.
+func (c Config) Adopt() Config {
+	c.Hash = HashSHA1
+	c.Legacy = true
+	return c
 }
 
-func (ctx *Context) loadSalt(outputDir string) error {
-	saltPath := fmt.Sprintf("%s/%s", outputDir, SaltFileName)
-	ctx.Salt = make([]byte, SaltLength)
-	if saltFile, err := os.Open(saltPath); err == nil {
-		// Read existing salt
-		bytesRead, err := saltFile.Read(ctx.Salt)
-		if err != nil {
-			return fmt.Errorf("failed to read salt: %s", err.Error())
-		} else if bytesRead != SaltLength {
-			return fmt.Errorf("incomplete salt read: %d of %d bytes read", bytesRead, SaltLength)
-		}
-	} else if os.IsNotExist(err) {
-		// Create salt
-		if bytesRead, err := rand.Read(ctx.Salt); err != nil || bytesRead != SaltLength {
-			return fmt.Errorf("failed to generate salt: %s", err.Error())
-		} else {
-			if err := ioutil.WriteFile(saltPath, ctx.Salt, 0655); err != nil {
-				return fmt.Errorf("failed to save salt: %s", err.Error())
-			}
-			log.Info("salt created")
-		}
-	} else {
-		return fmt.Errorf("failed to open salt file: %s", err.Error())
-	}
-	return nil
+func (c *Config) Encrypted() bool {
+	return c.Encryption != EncryptionNone
 }
 
-func (ctx *Context) initCrypto() error {
-	if passwordString, found := os.LookupEnv(passwordEnvVar); !found {
-		return fmt.Errorf("environment variable undefined: %s", passwordEnvVar)
-	} else {
-		password := []byte(passwordString)
-		key := pbkdf2.Key(password, ctx.Salt, keyIterations, keyLength, sha1.New)
-		block, err := aes.NewCipher(key)
-		if err != nil {
-			return fmt.Errorf("failed to init cipher: %s", err.Error())
-		}
-		ctx.cipherBlock = block
+func (c *Config) Validate() error {
+	switch c.Hash {
+	case HashSHA1, HashHMACSHA256, HashBLAKE3:
+	default:
+		return fmt.Errorf("unsupported hash algorithm: %s", c.Hash)
+	}
+
+	switch c.Encryption {
+	case EncryptionNone:
 		return nil
+	case EncryptionAES256GCM:
+	default:
+		return fmt.Errorf("unsupported encryption: %s", c.Encryption)
 	}
-}
 
-func (ctx *Context) NewHasher() (hash.Hash, error) {
-	hasher := ctx.newHash()
-	if bytesWritten, err := hasher.Write(ctx.Salt); err != nil {
-		return nil, fmt.Errorf("failed to write salt: %s", err.Error())
-	} else if bytesWritten != SaltLength {
-		return nil, fmt.Errorf("incomplete salt write: %d of %d bytes written", bytesWritten, SaltLength)
+	switch c.Padding {
+	case "", PaddingNone, PaddingPadme:
+	default:
+		return fmt.Errorf("unsupported padding: %s", c.Padding)
 	}
 
-	return hasher, nil
+	if c.KDF == nil {
+		return errors.New("key derivation parameters missing")
+	}
+	return c.KDF.validate()
 }
 
-func (ctx *Context) Encode(input []byte) ([]byte, error) {
-	if ctx.cipherBlock == nil {
-		return input, nil
-	}
+type Context struct {
+	Salt          []byte
+	hashAlgorithm string
+	hashKey       []byte
+	padding       string
+	cipherBlock   cipher.Block
+	// legacy allows cipher text without header, which is only found in adopted repositories
+	legacy bool
+}
 
-	cipher, err := cipher.NewGCM(ctx.cipherBlock)
-	if err != nil {
-		return nil, fmt.Errorf("failed to init cipher: %s", err.Error())
+func NewContext(outputDir string, config Config) (*Context, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
 	}
 
-	nonceSize := cipher.NonceSize()
-	blockSize := ctx.cipherBlock.BlockSize()
+	ctx := &Context{
+		hashAlgorithm: config.Hash,
+		padding:       config.Padding,
+		legacy:        config.Legacy}
 
-	inputSize := len(input)
-	paddingSize := 0
-	blockSizeModulo := inputSize % blockSize
-	if blockSizeModulo != 0 {
-		paddingSize += (blockSize - blockSizeModulo)
-	}
-	log.WithFields(log.Fields{
-		"block_size_modulo": blockSizeModulo,
-		"pading":            paddingSize,
-		"padded_size":       inputSize + paddingSize}).Debug("clear text padding")
-
-	paddedInput := make([]byte, inputSize+paddingSize)
-	copy(paddedInput, input)
-	if bytesRead, err := rand.Read(paddedInput[inputSize:]); err != nil {
-		return nil, fmt.Errorf("failed to pad clear text: %s", err.Error())
-	} else if bytesRead != paddingSize {
-		return nil, fmt.Errorf("incomplete clear text padding: %d out of %d bytes read", bytesRead, paddingSize)
+	if err := ctx.loadSalt(outputDir); err != nil {
+		return nil, err
 	}
 
-	nonce := make([]byte, nonceSize)
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, fmt.Errorf("failed to read nonce: %s", err.Error())
+	var masterKey []byte
+	if config.Encrypted() {
+		var err error
+		if masterKey, err = ctx.initCrypto(outputDir, config); err != nil {
+			return nil, err
+		}
+		log.Info("encryption enabled")
+	} else {
+		log.Info("encryption disabled")
 	}
 
-	log.WithFields(log.Fields{
-		"block_size":        blockSize,
-		"clear_text":        inputSize,
-		"padded_clear_text": len(paddedInput)}).Debug("encrypting")
-
-	cipherText := cipher.Seal(nil, nonce, paddedInput, nil)
-
-	output := append(nonce, cipherText...)
-	return output, nil
+	ctx.initHashKey(masterKey)
+	return ctx, nil
 }
 
-func (ctx *Context) Decode(input []byte) (output []byte, err error) {
-	if ctx.cipherBlock == nil {
-		return input, nil
+// CreateSalt creates the salt of a new backup directory. An existing salt is kept.
+func CreateSalt(outputDir string) error {
+	ctx := &Context{}
+	if err := ctx.loadSalt(outputDir); err == nil {
+		log.Info("using existing salt")
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
 	}
 
-	defer func() {
-		if r := recover(); r != nil {
-			output = nil
-			err = fmt.Errorf("decryption failed: %s", r)
-		}
-	}()
+	saltPath := filepath.Join(outputDir, SaltFileName)
+	salt := make([]byte, SaltLength)
+	if bytesRead, err := rand.Read(salt); err != nil || bytesRead != SaltLength {
+		return fmt.Errorf("failed to generate salt: %s", err.Error())
+	}
 
-	cipher, err := cipher.NewGCM(ctx.cipherBlock)
-	if err != nil {
-		return nil, fmt.Errorf("failed to init cipher: %s", err.Error())
+	if err := atomicfile.WriteFile(outputDir, saltPath, salt, 0644); err != nil {
+		return fmt.Errorf("failed to save salt: %s", err.Error())
 	}
+	log.Info("salt created")
+	return nil
+}
 
-	nonceSize := cipher.NonceSize()
-	blockSize := ctx.cipherBlock.BlockSize()
-	nonce := input[:nonceSize]
+func (ctx *Context) loadSalt(outputDir string) error {
+	saltPath := filepath.Join(outputDir, SaltFileName)
+	saltFile, err := os.Open(saltPath)
+	if err != nil {
+		return err
+	}
+	defer saltFile.Close()
 
-	cipherTextSize := len(input) - nonceSize
+	ctx.Salt = make([]byte, SaltLength)
+	bytesRead, err := io.ReadFull(saltFile, ctx.Salt)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return fmt.Errorf("incomplete salt read: %d of %d bytes read", bytesRead, SaltLength)
+	} else if err != nil {
+		return fmt.Errorf("failed to read salt: %s", err.Error())
+	}
+	return nil
+}
 
-	if cipherTextSize%blockSize != 0 {
-		return nil, fmt.Errorf("cipher text size is not a multiple of block size")
+func (ctx *Context) initCrypto(outputDir string, config Config) ([]byte, error) {
+	masterKey, _, err := unlockMasterKey(outputDir, config)
+	if err != nil {
+		return nil, err
 	}
-	log.WithField("size", cipherTextSize).Debug("decrypting block data")
 
-	output, err = cipher.Open(nil, nonce, input[nonceSize:], nil)
+	block, err := aes.NewCipher(masterKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt: %s", err.Error())
+		return nil, fmt.Errorf("failed to init cipher: %s", err.Error())
 	}
-	return output, nil
+	ctx.cipherBlock = block
+	return masterKey, nil
 }
@@ -0,0 +1,57 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+
+	"lukechampine.com/blake3"
+)
+
+const (
+	// HashSHA1 is SHA-1 of the salt followed by the data. It is kept for existing repositories.
+	HashSHA1 = "sha1"
+	// HashHMACSHA256 and HashBLAKE3 are keyed by the master key of encrypted repositories,
+	// so block IDs cannot be used to confirm the presence of known data.
+	HashHMACSHA256 = "hmac-sha256"
+	HashBLAKE3     = "blake3"
+
+	hashKeyContext = "kopi block id"
+)
+
+// HashAlgorithms lists the supported block hash algorithms, preferred first.
+var HashAlgorithms = []string{HashHMACSHA256, HashBLAKE3, HashSHA1}
+
+// initHashKey derives the key of the block hash from the master key, or from the salt of plaintext repositories.
+func (ctx *Context) initHashKey(masterKey []byte) {
+	secret := masterKey
+	if secret == nil {
+		secret = ctx.Salt
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(hashKeyContext))
+	ctx.hashKey = mac.Sum(nil)
+}
+
+// NewHasher returns a hash for block IDs using the algorithm of the repository.
+func (ctx *Context) NewHasher() (hash.Hash, error) {
+	switch ctx.hashAlgorithm {
+	case HashHMACSHA256:
+		return hmac.New(sha256.New, ctx.hashKey), nil
+	case HashBLAKE3:
+		return blake3.New(32, ctx.hashKey), nil
+	case HashSHA1:
+		hasher := sha1.New()
+		if bytesWritten, err := hasher.Write(ctx.Salt); err != nil {
+			return nil, fmt.Errorf("failed to write salt: %s", err.Error())
+		} else if bytesWritten != SaltLength {
+			return nil, fmt.Errorf("incomplete salt write: %d of %d bytes written", bytesWritten, SaltLength)
+		}
+		return hasher, nil
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm: %s", ctx.hashAlgorithm)
+	}
+}
@@ -0,0 +1,93 @@
+package security
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	KDFArgon2id   = "argon2id"
+	KDFScrypt     = "scrypt"
+	KDFPBKDF2SHA1 = "pbkdf2-sha1"
+)
+
+// KDFAlgorithms lists the supported key derivation functions, preferred first.
+var KDFAlgorithms = []string{KDFArgon2id, KDFScrypt, KDFPBKDF2SHA1}
+
+// KDFParams are the parameters used to derive an encryption key from a password.
+type KDFParams struct {
+	Algorithm string `json:"algorithm"`
+	// Iterations of PBKDF2 or passes of Argon2id
+	Iterations int `json:"iterations,omitempty"`
+	// Memory of Argon2id in KiB
+	Memory uint32 `json:"memory,omitempty"`
+	// Threads of Argon2id or parallelization of scrypt
+	Parallelism uint8 `json:"parallelism,omitempty"`
+	// CPU/memory cost (N) and block size (r) of scrypt
+	Cost      int `json:"cost,omitempty"`
+	BlockSize int `json:"blockSize,omitempty"`
+}
+
+// DefaultKDFParams returns the recommended parameters of an algorithm, or nil if it is not supported.
+func DefaultKDFParams(algorithm string) *KDFParams {
+	switch algorithm {
+	case KDFArgon2id:
+		return &KDFParams{Algorithm: KDFArgon2id, Iterations: 3, Memory: 64 * 1024, Parallelism: 4}
+	case KDFScrypt:
+		return &KDFParams{Algorithm: KDFScrypt, Cost: 32768, BlockSize: 8, Parallelism: 1}
+	case KDFPBKDF2SHA1:
+		return &KDFParams{Algorithm: KDFPBKDF2SHA1, Iterations: 100000}
+	default:
+		return nil
+	}
+}
+
+func (p *KDFParams) deriveKey(password, salt []byte) ([]byte, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+
+	switch p.Algorithm {
+	case KDFArgon2id:
+		return argon2.IDKey(password, salt, uint32(p.Iterations), p.Memory, p.Parallelism, keyLength), nil
+	case KDFScrypt:
+		key, err := scrypt.Key(password, salt, p.Cost, p.BlockSize, int(p.Parallelism), keyLength)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive key: %s", err.Error())
+		}
+		return key, nil
+	default:
+		return pbkdf2.Key(password, salt, p.Iterations, keyLength, sha1.New), nil
+	}
+}
+
+func (p *KDFParams) validate() error {
+	switch p.Algorithm {
+	case KDFArgon2id:
+		if p.Iterations < 1 {
+			return errors.New("argon2id iterations must be > 0")
+		} else if p.Parallelism < 1 {
+			return errors.New("argon2id parallelism must be > 0")
+		} else if p.Memory < 8*uint32(p.Parallelism) {
+			return errors.New("argon2id memory must be at least 8 KiB per thread")
+		}
+	case KDFScrypt:
+		if p.Cost < 2 || p.Cost&(p.Cost-1) != 0 {
+			return errors.New("scrypt cost must be a power of two > 1")
+		} else if p.BlockSize < 1 || p.Parallelism < 1 {
+			return errors.New("scrypt block size and parallelism must be > 0")
+		}
+	case KDFPBKDF2SHA1:
+		if p.Iterations < 1 {
+			return errors.New("key derivation iterations must be > 0")
+		}
+	default:
+		return fmt.Errorf("unsupported key derivation function: %s", p.Algorithm)
+	}
+	return nil
+}
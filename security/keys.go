@@ -0,0 +1,368 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mboye/kopi/atomicfile"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	KeysDirName   = "keys"
+	keyFileSuffix = ".json"
+	keyIDLength   = 8 // Bytes
+	keySaltLength = 32
+)
+
+var errWrongPassword = errors.New("wrong password")
+
+// KeySlot holds the master key of a repository wrapped under a key derived from a password.
+type KeySlot struct {
+	ID          string    `json:"id"`
+	Description string    `json:"description,omitempty"`
+	Created     time.Time `json:"created"`
+	KDF         KDFParams `json:"kdf"`
+	Salt        []byte    `json:"salt"`
+	WrappedKey  []byte    `json:"wrappedKey"`
+}
+
+// CreateMasterKey generates the master key of a new repository and wraps it under the password.
+func CreateMasterKey(dir string, config Config, description string) error {
+	password, err := Password.Read(true)
+	if err != nil {
+		return err
+	}
+
+	masterKey := make([]byte, keyLength)
+	if _, err := io.ReadFull(rand.Reader, masterKey); err != nil {
+		return fmt.Errorf("failed to generate master key: %s", err.Error())
+	}
+
+	slot, err := newKeySlot(*config.KDF, description)
+	if err != nil {
+		return err
+	}
+
+	if err := slot.wrap(masterKey, password); err != nil {
+		return err
+	}
+	return writeKeySlot(dir, slot)
+}
+
+// ListKeys returns the key slots of a repository ordered by creation time.
+func ListKeys(dir string) ([]*KeySlot, error) {
+	keysDir := filepath.Join(dir, KeysDirName)
+	entries, err := ioutil.ReadDir(keysDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %s", err.Error())
+	}
+
+	slots := []*KeySlot{}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), keyFileSuffix) {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(keysDir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key: %s", err.Error())
+		}
+
+		slot := &KeySlot{}
+		if err := json.Unmarshal(data, slot); err != nil {
+			return nil, fmt.Errorf("failed to decode key %s: %s", entry.Name(), err.Error())
+		}
+		slots = append(slots, slot)
+	}
+
+	sort.Slice(slots, func(a, b int) bool { return slots[a].Created.Before(slots[b].Created) })
+	return slots, nil
+}
+
+// AddKey wraps the master key under the new password. The current password must unlock the repository.
+// Repositories without key slots also get a slot for the current password, since the key is no longer
+// derived from the password once a slot exists.
+func AddKey(dir string, config Config, description string) (*KeySlot, error) {
+	masterKey, currentSlot, err := unlockMasterKey(dir, config)
+	if err != nil {
+		return nil, err
+	}
+
+	newPassword, err := NewPassword.Read(true)
+	if err != nil {
+		return nil, err
+	}
+
+	if currentSlot == nil {
+		password, err := Password.Read(false)
+		if err != nil {
+			return nil, err
+		}
+
+		if currentSlot, err = newKeySlot(*config.KDF, "initial key"); err != nil {
+			return nil, err
+		}
+
+		if err := currentSlot.wrap(masterKey, password); err != nil {
+			return nil, err
+		}
+
+		if err := writeKeySlot(dir, currentSlot); err != nil {
+			return nil, err
+		}
+	}
+
+	slot, err := newKeySlot(*config.KDF, description)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := slot.wrap(masterKey, newPassword); err != nil {
+		return nil, err
+	}
+
+	if err := writeKeySlot(dir, slot); err != nil {
+		return nil, err
+	}
+	return slot, nil
+}
+
+// RemoveKey removes a key slot. The last key of a repository cannot be removed.
+func RemoveKey(dir string, config Config, id string) error {
+	if _, _, err := unlockMasterKey(dir, config); err != nil {
+		return err
+	}
+
+	slots, err := ListKeys(dir)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, slot := range slots {
+		found = found || slot.ID == id
+	}
+
+	if !found {
+		return fmt.Errorf("key not found: %s", id)
+	} else if len(slots) == 1 {
+		return errors.New("cannot remove the last key of a repository")
+	}
+
+	if err := os.Remove(keySlotPath(dir, id)); err != nil {
+		return fmt.Errorf("failed to remove key: %s", err.Error())
+	}
+	log.WithField("id", id).Info("Removed key")
+	return nil
+}
+
+// ChangePassword re-wraps the key unlocked by the current password under the new password.
+// Repositories without key slots get their first slot.
+func ChangePassword(dir string, config Config) (*KeySlot, error) {
+	masterKey, slot, err := unlockMasterKey(dir, config)
+	if err != nil {
+		return nil, err
+	}
+
+	newPassword, err := NewPassword.Read(true)
+	if err != nil {
+		return nil, err
+	}
+
+	if slot == nil {
+		if slot, err = newKeySlot(*config.KDF, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := slot.wrap(masterKey, newPassword); err != nil {
+		return nil, err
+	}
+
+	if err := writeKeySlot(dir, slot); err != nil {
+		return nil, err
+	}
+	return slot, nil
+}
+
+// MigrateKey re-wraps the key unlocked by the current password using new key derivation parameters.
+// Repositories without key slots get their first slot. Blocks and manifests are not touched.
+func MigrateKey(dir string, config Config, params KDFParams) (*KeySlot, error) {
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+
+	masterKey, slot, err := unlockMasterKey(dir, config)
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := Password.Read(false)
+	if err != nil {
+		return nil, err
+	}
+
+	if slot == nil {
+		if slot, err = newKeySlot(params, ""); err != nil {
+			return nil, err
+		}
+	}
+	slot.KDF = params
+
+	if err := slot.wrap(masterKey, password); err != nil {
+		return nil, err
+	}
+
+	if err := writeKeySlot(dir, slot); err != nil {
+		return nil, err
+	}
+	return slot, nil
+}
+
+// unlockMasterKey returns the master key and the slot unlocked by the password. Adopted repositories
+// without key slots use the key derived from the password and repository salt, like data stored
+// before repositories were initialized explicitly.
+func unlockMasterKey(dir string, config Config) ([]byte, *KeySlot, error) {
+	if !config.Encrypted() {
+		return nil, nil, errors.New("repository is not encrypted")
+	}
+
+	return Password.unlock(dir, func(password []byte) ([]byte, *KeySlot, error) {
+		return findMasterKey(dir, config, password)
+	})
+}
+
+func findMasterKey(dir string, config Config, password []byte) ([]byte, *KeySlot, error) {
+	slots, err := ListKeys(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(slots) == 0 {
+		if !config.Legacy {
+			return nil, nil, errors.New("repository has no keys")
+		}
+
+		ctx := &Context{}
+		if err := ctx.loadSalt(dir); err != nil {
+			return nil, nil, err
+		}
+		log.Debug("deriving master key from password")
+		legacyKDF := KDFParams{Algorithm: KDFPBKDF2SHA1, Iterations: legacyKeyIterations}
+		masterKey, err := legacyKDF.deriveKey(password, ctx.Salt)
+		return masterKey, nil, err
+	}
+
+	for _, slot := range slots {
+		masterKey, err := slot.unwrap(password)
+		if err == errWrongPassword {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		log.WithField("key", slot.ID).Debug("unlocked master key")
+		return masterKey, slot, nil
+	}
+	return nil, nil, errors.New("password does not match any key")
+}
+
+func newKeySlot(params KDFParams, description string) (*KeySlot, error) {
+	id := make([]byte, keyIDLength)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return nil, fmt.Errorf("failed to generate key id: %s", err.Error())
+	}
+
+	return &KeySlot{
+		ID:          hex.EncodeToString(id),
+		Description: description,
+		Created:     time.Now().UTC(),
+		KDF:         params}, nil
+}
+
+// wrap encrypts the master key under a key derived from the password and a new salt.
+func (slot *KeySlot) wrap(masterKey, password []byte) error {
+	slot.Salt = make([]byte, keySaltLength)
+	if _, err := io.ReadFull(rand.Reader, slot.Salt); err != nil {
+		return fmt.Errorf("failed to generate key salt: %s", err.Error())
+	}
+
+	aead, err := slotCipher(password, slot)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return fmt.Errorf("failed to read nonce: %s", err.Error())
+	}
+
+	slot.WrappedKey = aead.Seal(nonce, nonce, masterKey, []byte(slot.ID))
+	return nil
+}
+
+func (slot *KeySlot) unwrap(password []byte) ([]byte, error) {
+	aead, err := slotCipher(password, slot)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := aead.NonceSize()
+	if len(slot.WrappedKey) < nonceSize {
+		return nil, fmt.Errorf("invalid key: %s", slot.ID)
+	}
+
+	masterKey, err := aead.Open(nil, slot.WrappedKey[:nonceSize], slot.WrappedKey[nonceSize:], []byte(slot.ID))
+	if err != nil {
+		return nil, errWrongPassword
+	}
+	return masterKey, nil
+}
+
+func slotCipher(password []byte, slot *KeySlot) (cipher.AEAD, error) {
+	key, err := slot.KDF.deriveKey(password, slot.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %s: %s", slot.ID, err.Error())
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init cipher: %s", err.Error())
+	}
+	return cipher.NewGCM(block)
+}
+
+func writeKeySlot(dir string, slot *KeySlot) error {
+	if err := os.MkdirAll(filepath.Join(dir, KeysDirName), 0700); err != nil {
+		return fmt.Errorf("failed to create keys directory: %s", err.Error())
+	}
+
+	data, err := json.MarshalIndent(slot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode key: %s", err.Error())
+	}
+
+	if err := atomicfile.WriteFile(dir, keySlotPath(dir, slot.ID), append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write key: %s", err.Error())
+	}
+	log.WithField("id", slot.ID).Info("Saved key")
+	return nil
+}
+
+func keySlotPath(dir, id string) string {
+	return filepath.Join(dir, KeysDirName, id+keyFileSuffix)
+}
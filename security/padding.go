@@ -0,0 +1,48 @@
+package security
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+const (
+	PaddingNone = "none"
+	// PaddingPadme pads to the sizes of the Padmé scheme. It leaks O(log log n) bits of the size
+	// with at most 12% overhead.
+	PaddingPadme = "padme"
+)
+
+// PaddingSchemes lists the supported padding schemes of encrypted data, preferred first.
+var PaddingSchemes = []string{PaddingPadme, PaddingNone}
+
+func (ctx *Context) paddedSize(size int) int {
+	if ctx.padding == PaddingPadme {
+		return padme(size)
+	}
+	return size
+}
+
+func padme(size int) int {
+	if size < 2 {
+		return size
+	}
+
+	exponent := bits.Len(uint(size)) - 1
+	mantissaBits := bits.Len(uint(exponent))
+	mask := (1 << uint(exponent-mantissaBits)) - 1
+	return (size + mask) &^ mask
+}
+
+// unpad returns the clear text of version 2 cipher text without its length and padding.
+func unpad(clearText []byte) ([]byte, error) {
+	if len(clearText) < lengthSize {
+		return nil, fmt.Errorf("clear text too short")
+	}
+
+	size := binary.BigEndian.Uint64(clearText)
+	if size > uint64(len(clearText)-lengthSize) {
+		return nil, fmt.Errorf("clear text length %d exceeds data", size)
+	}
+	return clearText[lengthSize : lengthSize+int(size)], nil
+}
@@ -0,0 +1,180 @@
+package security
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/term"
+)
+
+const terminalPath = "/dev/tty"
+
+// PasswordSource describes where a password is read from. The first configured source is used,
+// in this order: file descriptor, file, command, environment variable, terminal prompt.
+type PasswordSource struct {
+	Name string
+	// FD is an inherited file descriptor, or -1 if not used
+	FD      int
+	File    string
+	Command string
+	EnvVar  string
+	// Prompt enables asking for the password on the terminal when no other source is configured
+	Prompt bool
+
+	lock     sync.Mutex
+	password []byte
+
+	// masterKeys caches the master keys unlocked by the password by repository, since every stage unlocks
+	// its repository and key derivation is slow by design
+	keysLock   sync.Mutex
+	masterKeys map[string]unlockedKey
+}
+
+type unlockedKey struct {
+	masterKey []byte
+	slot      *KeySlot
+}
+
+// Password is the source of the password which unlocks encrypted repositories.
+var Password = &PasswordSource{Name: "password", FD: -1, EnvVar: "KOPI_PASSWORD", Prompt: true}
+
+// NewPassword is the source of the password of a key being added or changed.
+var NewPassword = &PasswordSource{Name: "new password", FD: -1, EnvVar: "KOPI_NEW_PASSWORD", Prompt: true}
+
+// Read returns the password. It is only read once, since file descriptors and prompts cannot be repeated.
+// Passwords typed on the terminal must be entered twice if confirm is set.
+func (s *PasswordSource) Read(confirm bool) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.password != nil {
+		return s.password, nil
+	}
+
+	password, err := s.read(confirm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", s.Name, err.Error())
+	}
+
+	s.password = password
+	return password, nil
+}
+
+// unlock returns the master key of the repository at dir and the slot it was unlocked from. Only the first
+// call for a repository reads the password and calls find.
+func (s *PasswordSource) unlock(dir string, find func(password []byte) ([]byte, *KeySlot, error)) ([]byte, *KeySlot, error) {
+	s.keysLock.Lock()
+	defer s.keysLock.Unlock()
+
+	if absDir, err := filepath.Abs(dir); err == nil {
+		dir = absDir
+	}
+
+	if key, found := s.masterKeys[dir]; found {
+		return key.masterKey, key.slot, nil
+	}
+
+	password, err := s.Read(false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	masterKey, slot, err := find(password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if s.masterKeys == nil {
+		s.masterKeys = map[string]unlockedKey{}
+	}
+	s.masterKeys[dir] = unlockedKey{masterKey, slot}
+	return masterKey, slot, nil
+}
+
+func (s *PasswordSource) read(confirm bool) ([]byte, error) {
+	if s.FD >= 0 {
+		log.WithField("fd", s.FD).Debug("reading password from file descriptor")
+		file := os.NewFile(uintptr(s.FD), "password")
+		if file == nil {
+			return nil, fmt.Errorf("invalid file descriptor: %d", s.FD)
+		}
+		defer file.Close()
+
+		data, err := ioutil.ReadAll(file)
+		if err != nil {
+			return nil, err
+		}
+		return trimNewline(data), nil
+	}
+
+	if s.File != "" {
+		log.WithField("path", s.File).Debug("reading password from file")
+		data, err := ioutil.ReadFile(s.File)
+		if err != nil {
+			return nil, err
+		}
+		return trimNewline(data), nil
+	}
+
+	if s.Command != "" {
+		log.WithField("command", s.Command).Debug("reading password from command")
+		cmd := exec.Command("/bin/sh", "-c", s.Command)
+		cmd.Stderr = os.Stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("password command failed: %s", err.Error())
+		}
+		return trimNewline(output), nil
+	}
+
+	if password, found := os.LookupEnv(s.EnvVar); found {
+		return []byte(password), nil
+	}
+
+	if s.Prompt {
+		if tty, err := os.OpenFile(terminalPath, os.O_RDWR, 0); err == nil {
+			defer tty.Close()
+			return s.prompt(tty, confirm)
+		}
+	}
+
+	return nil, fmt.Errorf("environment variable undefined: %s (no terminal to prompt on)", s.EnvVar)
+}
+
+func (s *PasswordSource) prompt(tty *os.File, confirm bool) ([]byte, error) {
+	fmt.Fprintf(tty, "Enter %s: ", s.Name)
+	password, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	if err != nil {
+		return nil, err
+	}
+
+	if !confirm {
+		return password, nil
+	}
+
+	fmt.Fprintf(tty, "Repeat %s: ", s.Name)
+	repeated, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(tty)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(password, repeated) {
+		return nil, errors.New("passwords do not match")
+	}
+	return password, nil
+}
+
+// trimNewline removes the line break which ends most password files and command output.
+func trimNewline(data []byte) []byte {
+	data = bytes.TrimSuffix(data, []byte("\n"))
+	return bytes.TrimSuffix(data, []byte("\r"))
+}
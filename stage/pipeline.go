@@ -0,0 +1,71 @@
+package stage
+
+import (
+	"context"
+
+	"github.com/mboye/kopi/input"
+	"github.com/mboye/kopi/model"
+	"github.com/mboye/kopi/outputhandler"
+)
+
+// Number of files buffered between stages
+const channelSize = 1024
+
+// RunPipeline executes stages concurrently in one process, passing the output of each stage to the input
+// of the next. The last stage runs in the calling goroutine, and failures of earlier stages are passed on
+// to it through its input, so its error is the result of the pipeline.
+func RunPipeline(ctx context.Context, first Producer, filters []Filter, last Consumer) error {
+	// Aborting unblocks stages waiting to pass on files. It does not cancel ctx, so each stage still
+	// reports its own failure.
+	pipeline, abortPipeline := context.WithCancel(ctx)
+	defer abortPipeline()
+
+	// connect runs a stage which passes its output to the source it returns
+	connect := func(producer Producer) input.Source {
+		files := make(chan *model.File, channelSize)
+		result := make(chan error, 1)
+		producer.SetOutput(outputhandler.NewChannelHandler(files, pipeline.Done()))
+
+		go func() {
+			err := producer.Execute(ctx)
+			if err != nil {
+				abortPipeline()
+			}
+			result <- err
+			close(files)
+		}()
+		return input.FromChannel(files, result)
+	}
+
+	producer := first
+	for _, filter := range filters {
+		filter.SetInput(connect(producer))
+		producer = filter
+	}
+	last.SetInput(connect(producer))
+
+	if err := last.Execute(ctx); err != nil {
+		abortPipeline()
+		return err
+	}
+	return nil
+}
+
+// Observe returns a producer which passes each file output by p to observe before passing it on.
+func Observe(p Producer, observe func(file *model.File)) Producer {
+	return &observer{p, observe}
+}
+
+type observer struct {
+	Producer
+	observe func(file *model.File)
+}
+
+func (o *observer) SetOutput(output outputhandler.OutputHandler) {
+	o.Producer.SetOutput(outputhandler.Func(func(obj interface{}) error {
+		if file, ok := obj.(*model.File); ok {
+			o.observe(file)
+		}
+		return output.Handle(obj)
+	}))
+}
@@ -1,5 +1,33 @@
 package stage
 
+import (
+	"context"
+
+	"github.com/mboye/kopi/input"
+	"github.com/mboye/kopi/outputhandler"
+)
+
+// Stage is a step of a backup or restore. Execute stops early with ctx.Err() once ctx is cancelled,
+// after closing the files it has opened.
 type Stage interface {
-	Execute() error
+	Execute(ctx context.Context) error
+}
+
+// Producer is a stage whose output can be redirected from STDOUT, e.g. to another stage in the same process.
+type Producer interface {
+	Stage
+	SetOutput(output outputhandler.OutputHandler)
+}
+
+// Consumer is a stage whose input can be redirected from STDIN.
+type Consumer interface {
+	Stage
+	SetInput(source input.Source)
+}
+
+// Filter is a stage whose input and output can both be redirected.
+type Filter interface {
+	Stage
+	SetInput(source input.Source)
+	SetOutput(output outputhandler.OutputHandler)
 }
@@ -0,0 +1,188 @@
+package storer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mboye/kopi/index"
+	"github.com/mboye/kopi/model"
+	"github.com/mboye/kopi/security"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// CheckpointFileName is the file in a repository which lists the files stored by an unfinished run
+	CheckpointFileName     = "store.checkpoint"
+	checkpointSyncInterval = 10 * time.Second
+)
+
+// checkpointIdentity is authenticated along with every entry of the checkpoint of an encrypted repository
+var checkpointIdentity = security.Identity{Type: security.ObjectCheckpoint, ID: CheckpointFileName}
+
+// checkpoint appends stored files to the checkpoint file. It is synced regularly, so a resumed run
+// only has to store the files completed since the last sync. Each file is one line of JSON, which is
+// encrypted and base64 encoded in encrypted repositories.
+type checkpoint struct {
+	file            *os.File
+	securityContext *security.Context
+	lastSync        time.Time
+}
+
+func checkpointPath(outputDir string) string {
+	return filepath.Join(outputDir, CheckpointFileName)
+}
+
+// loadCheckpoint reads the files stored by an interrupted run. Entries which cannot be read, like a line
+// cut off by a crash, are skipped.
+func loadCheckpoint(outputDir string, securityContext *security.Context) (index.Index, error) {
+	stored := index.New()
+
+	checkpointFile, err := os.Open(checkpointPath(outputDir))
+	if os.IsNotExist(err) {
+		log.Warn("No checkpoint found. All files will be stored.")
+		return stored, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint: %s", err.Error())
+	}
+	defer checkpointFile.Close()
+
+	reader := bufio.NewReader(checkpointFile)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read checkpoint: %s", err.Error())
+		}
+
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if file, decodeErr := decodeCheckpointEntry(line, securityContext); decodeErr != nil {
+				log.WithError(decodeErr).Warn("Ignoring incomplete checkpoint entry")
+			} else if stored.Find(file.OSPath()) == nil {
+				stored.Add(file)
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	log.WithField("files", stored.Size()).Info("Loaded checkpoint")
+	return stored, nil
+}
+
+func encodeCheckpointEntry(file *model.File, securityContext *security.Context) ([]byte, error) {
+	data, err := json.Marshal(file)
+	if err != nil || !securityContext.Encrypted() {
+		return data, err
+	}
+
+	encoded, err := securityContext.Encode(data, checkpointIdentity)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(encoded)), nil
+}
+
+func decodeCheckpointEntry(line []byte, securityContext *security.Context) (*model.File, error) {
+	if securityContext.Encrypted() {
+		encoded, err := base64.StdEncoding.DecodeString(string(line))
+		if err != nil {
+			return nil, err
+		}
+
+		if line, err = securityContext.Decode(encoded, checkpointIdentity); err != nil {
+			return nil, err
+		}
+	}
+
+	file := &model.File{}
+	if err := json.Unmarshal(line, file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func openCheckpoint(outputDir string, securityContext *security.Context, resume bool) (*checkpoint, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if resume {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	file, err := os.OpenFile(checkpointPath(outputDir), flags, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint: %s", err.Error())
+	}
+
+	// Checkpoints created with a wider mode by earlier versions are restricted too
+	if err := file.Chmod(0600); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to restrict checkpoint permissions: %s", err.Error())
+	}
+
+	return &checkpoint{file, securityContext, time.Now()}, nil
+}
+
+func (c *checkpoint) add(file *model.File) error {
+	entry, err := encodeCheckpointEntry(file, c.securityContext)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint entry: %s", err.Error())
+	}
+
+	if _, err := c.file.Write(append(entry, '\n')); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %s", err.Error())
+	}
+
+	if time.Since(c.lastSync) < checkpointSyncInterval {
+		return nil
+	}
+
+	c.lastSync = time.Now()
+	if err := c.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync checkpoint: %s", err.Error())
+	}
+	return nil
+}
+
+func (c *checkpoint) close() error {
+	defer c.file.Close()
+	if err := c.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync checkpoint: %s", err.Error())
+	}
+	return nil
+}
+
+// remove deletes the checkpoint of a completed run.
+func (c *checkpoint) remove() error {
+	c.file.Close()
+	if err := os.Remove(c.file.Name()); err != nil {
+		return fmt.Errorf("failed to remove checkpoint: %s", err.Error())
+	}
+	return nil
+}
+
+// resumeFile copies the blocks of a file from the checkpoint, if it is unchanged since it was stored.
+func resumeFile(file *model.File, stored index.Index) bool {
+	previous := stored.Find(file.OSPath())
+	if previous == nil {
+		return false
+	}
+
+	info, err := os.Stat(file.OSPath())
+	if err != nil || info.Size() != previous.Size || !info.ModTime().Equal(previous.ModifiedTime) {
+		return false
+	}
+
+	file.Size = previous.Size
+	file.ModifiedTime = previous.ModifiedTime
+	file.Blocks = previous.Blocks
+	file.Holes = previous.Holes
+	file.ContentHash = previous.ContentHash
+	return true
+}
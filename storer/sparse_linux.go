@@ -0,0 +1,61 @@
+//go:build linux
+// +build linux
+
+package storer
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+const (
+	seekData = 3 // SEEK_DATA
+	seekHole = 4 // SEEK_HOLE
+)
+
+// dataExtents finds the regions of a file which contain data using SEEK_DATA and SEEK_HOLE.
+// Filesystems without hole detection report the whole file as data.
+func dataExtents(inputFile *os.File, size int64) ([]extent, error) {
+	extents := []extent{}
+	offset := int64(0)
+	for offset < size {
+		dataStart, err := inputFile.Seek(offset, seekData)
+		if isErrno(err, syscall.ENXIO) {
+			// No data beyond offset
+			break
+		} else if isErrno(err, syscall.EINVAL) {
+			return []extent{{offset: 0, size: size}}, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if dataStart >= size {
+			break
+		}
+
+		dataEnd, err := inputFile.Seek(dataStart, seekHole)
+		if err != nil {
+			return nil, err
+		}
+
+		if dataEnd > size {
+			dataEnd = size
+		}
+
+		extents = append(extents, extent{offset: dataStart, size: dataEnd - dataStart})
+		offset = dataEnd
+	}
+
+	if _, err := inputFile.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return extents, nil
+}
+
+func isErrno(err error, errno syscall.Errno) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		return pathErr.Err == errno
+	}
+	return err == errno
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package storer
+
+import "os"
+
+// dataExtents reports the whole file as data on platforms without hole detection.
+func dataExtents(inputFile *os.File, size int64) ([]extent, error) {
+	if size == 0 {
+		return []extent{}, nil
+	}
+	return []extent{{offset: 0, size: size}}, nil
+}
@@ -1,6 +1,7 @@
 package storer
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -9,9 +10,12 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/mboye/kopi/atomicfile"
+	"github.com/mboye/kopi/index"
 	"github.com/mboye/kopi/input"
 	"github.com/mboye/kopi/model"
 	"github.com/mboye/kopi/outputhandler"
+	"github.com/mboye/kopi/repository"
 	"github.com/mboye/kopi/security"
 	"github.com/mboye/kopi/stage"
 	log "github.com/sirupsen/logrus"
@@ -21,12 +25,23 @@ type storer struct {
 	outputDir        string
 	maxBlockSize     int64
 	encrypt          bool
+	checkpoint       bool
+	resume           bool
 	progressInterval uint
+	source           input.Source
+	output           outputhandler.OutputHandler
 }
 
-var _ stage.Stage = (*storer)(nil)
+var _ stage.Filter = (*storer)(nil)
 
-func New(outputDir string, maxBlockSize int64, encrypt bool, progressInterval uint) (stage.Stage, error) {
+// extent is a region of a file which contains data.
+type extent struct {
+	offset, size int64
+}
+
+// New creates a stage which stores the modified files on its input as blocks. With checkpoint, stored files are
+// recorded in a checkpoint, so an interrupted run can be resumed without reading them again.
+func New(outputDir string, maxBlockSize int64, encrypt, checkpoint, resume bool, progressInterval uint) (stage.Filter, error) {
 	if outputDir == "" {
 		return nil, errors.New("cannot store to empty output dir")
 	}
@@ -39,77 +54,180 @@ func New(outputDir string, maxBlockSize int64, encrypt bool, progressInterval ui
 		return nil, errors.New("progress interval must be >= 1")
 	}
 
+	if resume && !checkpoint {
+		return nil, errors.New("cannot resume without checkpoint")
+	}
+
 	return &storer{
 		outputDir,
-		maxBlockSize, encrypt, progressInterval}, nil
+		maxBlockSize, encrypt, checkpoint, resume, progressInterval,
+		input.Stdin, outputhandler.Stdout}, nil
 }
 
-func (s *storer) Execute() error {
-	securityContext, err := security.NewContext(s.outputDir, s.encrypt)
+func (s *storer) SetInput(source input.Source) {
+	s.source = source
+}
+
+func (s *storer) SetOutput(output outputhandler.OutputHandler) {
+	s.output = output
+}
+
+func (s *storer) Execute(ctx context.Context) error {
+	securityContext, err := repository.NewSecurityContext(s.outputDir, s.encrypt)
 	if err != nil {
-		log.WithField("error", err).Fatal("failed to create security context")
+		return fmt.Errorf("failed to create security context: %s", err.Error())
+	}
+
+	if err := atomicfile.Sweep(s.outputDir); err != nil {
+		return err
+	}
+
+	stored := index.New()
+	var checkpoint *checkpoint
+	if s.checkpoint {
+		if s.resume {
+			if stored, err = loadCheckpoint(s.outputDir, securityContext); err != nil {
+				return err
+			}
+		} else if _, err := os.Stat(checkpointPath(s.outputDir)); err == nil {
+			log.Warn("Discarding checkpoint of an interrupted run. Use -resume to continue it.")
+		}
+
+		if checkpoint, err = openCheckpoint(s.outputDir, securityContext, s.resume); err != nil {
+			return err
+		}
 	}
 
 	filterAndStoreFile := func(file *model.File) error {
-		if file.Mode.IsDir() {
-			outputhandler.Stdout.Handle(file)
-			return nil
+		if file.Mode.IsDir() || file.IsSymlink() {
+			// Directories and symbolic links are fully described by their index line
+			return s.output.Handle(file)
 		}
 
 		if !file.Modified {
-			log.WithField("path", file.Path).Debug("Skipping unmodified file")
-			outputhandler.Stdout.Handle(file)
-			return nil
+			if file.MetadataModified {
+				log.WithField("path", file.Path).Debug("Storing metadata of file with unmodified content")
+			} else {
+				log.WithField("path", file.Path).Debug("Skipping unmodified file")
+			}
+			return s.output.Handle(file)
+		}
+
+		if resumeFile(file, stored) {
+			log.WithField("path", file.Path).Debug("Resuming file stored by interrupted run")
+			return s.output.Handle(file)
 		}
 
-		err := storeFile(file, s.outputDir, securityContext, s.maxBlockSize)
+		err := storeFile(ctx, file, s.outputDir, securityContext, s.maxBlockSize)
 		if os.IsNotExist(err) {
 			log.WithField("path", file.Path).Warn("File not found")
 			return nil
 		} else if os.IsPermission(err) {
 			log.WithField("path", file.Path).Warn("Permission denied")
 			return nil
+		} else if err != nil {
+			return err
 		}
-		return err
+
+		if checkpoint != nil {
+			if err := checkpoint.add(file); err != nil {
+				return err
+			}
+		}
+		return s.output.Handle(file)
 	}
 
 	log.WithField("destination", s.outputDir).Info("Beginning to store files")
-	return input.ProcessFilesWithProgress(filterAndStoreFile, s.progressInterval)
+	if err := input.ProcessSourceWithProgress(ctx, s.source, filterAndStoreFile, s.progressInterval); err != nil {
+		if checkpoint == nil {
+			return err
+		} else if closeErr := checkpoint.close(); closeErr != nil {
+			log.WithError(closeErr).Error("Failed to save checkpoint")
+		} else {
+			log.Info("Saved checkpoint. Run again with -resume to continue.")
+		}
+		return err
+	}
+
+	if checkpoint == nil {
+		return nil
+	}
+	return checkpoint.remove()
 }
 
-func storeFile(file *model.File, outputDir string, securityContext *security.Context, maxBlockSize int64) error {
+// storeFile stores the data of a file as blocks. A cancelled ctx stops it between blocks, so the file is
+// left out of the checkpoint and stored again on resume.
+func storeFile(ctx context.Context, file *model.File, outputDir string, securityContext *security.Context, maxBlockSize int64) error {
 	if err := refreshFileMetadata(file); err != nil {
 		return err
 	}
 
 	logger := log.WithField("path", file.Path)
 
-	inputFile, err := os.Open(file.Path)
+	inputFile, err := os.Open(file.OSPath())
 	if err != nil {
 		return err
 	}
 	defer inputFile.Close()
-	fileReader := io.LimitReader(inputFile, file.Size)
+
+	extents, err := dataExtents(inputFile, file.Size)
+	if err != nil {
+		return fmt.Errorf("failed to detect holes: %s", err.Error())
+	}
+	file.Holes = findHoles(extents, file.Size)
+	if len(file.Holes) > 0 {
+		logger.WithField("holes", len(file.Holes)).Debug("Detected sparse file")
+	}
 
 	logger.WithField("max_offset", file.Size).Debug("Read file")
 
-	fileOffset := int64(0)
-	for fileOffset < file.Size {
-		blockReader := io.LimitReader(fileReader, maxBlockSize)
+	for _, dataExtent := range extents {
+		extentReader := io.NewSectionReader(inputFile, dataExtent.offset, dataExtent.size)
+		err := StoreBlocks(ctx, extentReader, file, dataExtent.offset, dataExtent.size, outputDir, securityContext, maxBlockSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Debug("File read completed")
+	return nil
+}
+
+// StoreBlocks reads size bytes from r and adds them to file as blocks, starting at the file offset.
+// A negative size reads r until EOF and sets the size of file. Blocks which exist in outputDir already
+// are reused. A cancelled ctx stops it between blocks.
+func StoreBlocks(ctx context.Context, r io.Reader, file *model.File, offset, size int64, outputDir string,
+	securityContext *security.Context, maxBlockSize int64) error {
+	logger := log.WithField("path", file.Path)
+	end := offset + size
+
+	fileOffset := offset
+	for size < 0 || fileOffset < end {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		blockReader := io.LimitReader(r, maxBlockSize)
 		blockOffset := fileOffset
 
 		hasher, err := securityContext.NewHasher()
 		if err != nil {
-			log.WithField("error", err).Fatal("failed to get hasher")
+			return fmt.Errorf("failed to get hasher: %s", err.Error())
 		}
 
 		blockData, err := ioutil.ReadAll(blockReader)
+		if err != nil {
+			return err
+		}
 		bytesRead := len(blockData)
 		logger.WithField("bytes_read", bytesRead).Debug("Read file")
 
+		if size < 0 && bytesRead == 0 {
+			break
+		}
 		fileOffset += int64(bytesRead)
 
-		if int64(bytesRead) != maxBlockSize && fileOffset != file.Size {
+		if size >= 0 && int64(bytesRead) != maxBlockSize && fileOffset != end {
 			return errors.New("Incomplete buffer read")
 		}
 
@@ -126,57 +244,59 @@ func storeFile(file *model.File, outputDir string, securityContext *security.Con
 			continue
 		}
 
-		encodedBlockData, err := securityContext.Encode(blockData)
-		if err != nil {
-			return err
-		}
-
-		blockDirPath := fmt.Sprintf("%s/%s", outputDir, hash[:2])
-		err = os.MkdirAll(blockDirPath, 0755)
+		encodedBlockData, err := securityContext.Encode(blockData, security.BlockIdentity(hash))
 		if err != nil {
 			return err
 		}
 
-		outputFile, err := os.Create(outputPath)
-		if err != nil {
+		if err := atomicfile.WriteFile(outputDir, outputPath, encodedBlockData, 0644); err != nil {
 			return err
 		}
-		logger.WithField("output_path", outputPath).Debug("Created output file")
-
-		bytesWritten, err := outputFile.Write(encodedBlockData)
-		if err != nil {
-			outputFile.Close()
-			return err
-		}
-
-		if bytesWritten != len(encodedBlockData) {
-			return errors.New("Incomplete block write")
-		}
+		logger.WithField("output_path", outputPath).Debug("Wrote output file")
 
-		outputFile.Close()
 		file.AddBlock(block)
 		logger.WithFields(log.Fields{"hash": hash, "offset": blockOffset, "size": blockSize}).Debug("Block created")
 
 		logger.WithField("fileOffset", fileOffset).Debug("File offset")
 	}
 
-	logger.Debug("File read completed")
-	outputhandler.Stdout.Handle(file)
+	if size < 0 {
+		file.Size = fileOffset
+	}
 	return nil
 }
 
+// findHoles returns the regions of a file which are not covered by data extents.
+func findHoles(extents []extent, size int64) []model.Hole {
+	holes := []model.Hole{}
+	offset := int64(0)
+	for _, dataExtent := range extents {
+		if dataExtent.offset > offset {
+			holes = append(holes, model.Hole{Offset: offset, Size: dataExtent.offset - offset})
+		}
+		offset = dataExtent.offset + dataExtent.size
+	}
+
+	if offset < size {
+		holes = append(holes, model.Hole{Offset: offset, Size: size - offset})
+	}
+	return holes
+}
+
 func refreshFileMetadata(file *model.File) error {
-	if fileInfo, err := os.Stat(file.Path); err != nil {
+	if fileInfo, err := os.Stat(file.OSPath()); err != nil {
 		log.WithError(err).Error("Failed to get file metadata")
 		return err
 	} else {
 		if file.Size != fileInfo.Size() {
 			log.WithFields(log.Fields{"path": file.Path, "expected_size": file.Size, "actual_size": fileInfo.Size()}).Warn("File size has changed")
+			file.ContentHash = ""
 		}
 		file.Size = fileInfo.Size()
 
 		if file.ModifiedTime.UTC() != fileInfo.ModTime().UTC() {
 			log.WithFields(log.Fields{"path": file.Path, "expected_modtime": file.ModifiedTime, "actual_modtime": fileInfo.ModTime()}).Warn("File modification time has changed")
+			file.ContentHash = ""
 		}
 		file.ModifiedTime = fileInfo.ModTime().UTC()
 
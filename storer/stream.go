@@ -0,0 +1,81 @@
+package storer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/mboye/kopi/atomicfile"
+	"github.com/mboye/kopi/model"
+	"github.com/mboye/kopi/outputhandler"
+	"github.com/mboye/kopi/repository"
+	"github.com/mboye/kopi/stage"
+	log "github.com/sirupsen/logrus"
+)
+
+type streamStorer struct {
+	outputDir    string
+	name         string
+	maxBlockSize int64
+	encrypt      bool
+	input        io.Reader
+	output       outputhandler.OutputHandler
+}
+
+var _ stage.Producer = (*streamStorer)(nil)
+
+// NewStream creates a stage which stores the bytes on STDIN as the blocks of a single file at the virtual
+// path name, e.g. the output of a database dump. The file is output once the stream ends.
+func NewStream(outputDir, name string, maxBlockSize int64, encrypt bool) (stage.Producer, error) {
+	if outputDir == "" {
+		return nil, errors.New("cannot store to empty output dir")
+	}
+
+	if name == "" {
+		return nil, errors.New("stream name cannot be empty")
+	}
+
+	if maxBlockSize < 1 {
+		return nil, errors.New("max block size must be > 0")
+	}
+
+	return &streamStorer{outputDir, name, maxBlockSize, encrypt, os.Stdin, outputhandler.Stdout}, nil
+}
+
+func (s *streamStorer) SetOutput(output outputhandler.OutputHandler) {
+	s.output = output
+}
+
+func (s *streamStorer) Execute(ctx context.Context) error {
+	securityContext, err := repository.NewSecurityContext(s.outputDir, s.encrypt)
+	if err != nil {
+		return fmt.Errorf("failed to create security context: %s", err.Error())
+	}
+
+	if err := atomicfile.Sweep(s.outputDir); err != nil {
+		return err
+	}
+
+	startTime := time.Now()
+	file := &model.File{
+		Mode:         0644,
+		ModifiedTime: startTime.UTC(),
+		Modified:     true}
+	file.SetPath(s.name)
+
+	log.WithFields(log.Fields{"path": s.name, "destination": s.outputDir}).Info("Beginning to store stream")
+	if err := StoreBlocks(ctx, s.input, file, 0, -1, s.outputDir, securityContext, s.maxBlockSize); err != nil {
+		return fmt.Errorf("failed to store stream: %s", err.Error())
+	}
+
+	log.WithFields(log.Fields{
+		"path":         s.name,
+		"size":         humanize.Bytes(uint64(file.Size)),
+		"blocks":       len(file.Blocks),
+		"elapsed_time": time.Since(startTime).Round(time.Second).String()}).Info("Stored stream")
+	return s.output.Handle(file)
+}
@@ -9,4 +9,6 @@ robot --debugfile debug.log \
     test/diffing.robot \
     test/store.robot \
     test/restore.robot \
-    test/manifest.robot
+    test/manifest.robot \
+    test/backup.robot \
+    test/repository.robot
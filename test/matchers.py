@@ -2,6 +2,9 @@ import re
 import json
 import hashlib
 import gzip
+import io
+import os
+import tarfile
 from datetime import datetime
 
 ROBOT_LIBRARY_SCOPE = 'TEST CASE'
@@ -175,3 +178,34 @@ def file_should_be_utf8_encoded(path):
 def get_decompressed_file(path):
     with gzip.open(path, 'rb') as fp:
         return fp.read().decode('utf8')
+
+def create_tar_with_unsafe_members(path):
+    with tarfile.open(path, 'w') as archive:
+        for name in ['../escape.txt', '/absolute/file.txt', 'safe/../../escape.txt']:
+            data = b'unsafe\n'
+            member = tarfile.TarInfo(name)
+            member.size = len(data)
+            archive.addfile(member, io.BytesIO(data))
+
+        link = tarfile.TarInfo('link.txt')
+        link.type = tarfile.LNKTYPE
+        link.linkname = '../escape.txt'
+        archive.addfile(link)
+
+        # Members below a symbolic link would be restored wherever it points, here next to the archive
+        symlink = tarfile.TarInfo('escape')
+        symlink.type = tarfile.SYMTYPE
+        symlink.linkname = os.path.dirname(os.path.abspath(path))
+        archive.addfile(symlink)
+
+        data = b'unsafe\n'
+        member = tarfile.TarInfo('escape/escape.txt')
+        member.size = len(data)
+        archive.addfile(member, io.BytesIO(data))
+
+def should_be_index_line_with_owner_of(line, path):
+    doc = json.loads(line)
+    info = os.lstat(path)
+    actual = (doc.get('uid', 0), doc.get('gid', 0))
+    if actual != (info.st_uid, info.st_gid):
+        raise AssertionError('Expected owner {}/{}, but got {}/{}'.format(info.st_uid, info.st_gid, *actual))